@@ -0,0 +1,207 @@
+package litmus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	. "github.com/blitz-frost/log"
+	"github.com/pion/webrtc/v3"
+)
+
+const defaultResultLimit = 100
+
+// SessionInfo is a snapshot of an active test connection
+type SessionInfo struct {
+	ID            string    `json:"id"`
+	RemoteAddr    string    `json:"remote_addr"`
+	Started       time.Time `json:"started"`
+	Direction     Direction `json:"direction"`                // requested direction, download until the offer arrives
+	Phase         Direction `json:"phase"`                    // direction currently under test, empty before and after the test
+	Bitrate       int       `json:"bitrate"`                  // kbps, current target of the phase under test
+	ICEState      string    `json:"ice_state"`                // ICE connection state of the peer connection
+	BytesSent     uint64    `json:"bytes_sent"`               // SCTP payload bytes sent to the client
+	BytesReceived uint64    `json:"bytes_received"`           // SCTP payload bytes received from the client
+	QueuePosition int       `json:"queue_position,omitempty"` // place in the waiting room, 0 if not waiting
+}
+
+// info takes a snapshot of the session
+func (sess *session) info() SessionInfo {
+	sess.mu.Lock()
+	info := SessionInfo{
+		ID:         sess.connID,
+		RemoteAddr: sess.remoteAddr,
+		Started:    sess.started,
+		Direction:  sess.direction,
+		Phase:      sess.phase,
+	}
+	tuner := sess.downloadTuner
+	if sess.phase == DirectionUpload {
+		tuner = sess.uploadTuner
+	}
+	sess.mu.Unlock()
+
+	if info.Phase != "" && tuner != nil {
+		info.Bitrate = tuner.getCurrentBitrate()
+	}
+
+	info.ICEState = sess.peerConnection.ICEConnectionState().String()
+	for _, stats := range sess.peerConnection.GetStats() {
+		if transport, ok := stats.(webrtc.TransportStats); ok {
+			info.BytesSent += transport.BytesSent
+			info.BytesReceived += transport.BytesReceived
+		}
+	}
+	return info
+}
+
+// Sessions lists the active sessions, oldest first
+func (s *Server) Sessions() []SessionInfo {
+	sessions := []SessionInfo{}
+	s.connections.Range(func(key, value interface{}) bool {
+		sessions = append(sessions, s.sessionInfo(value.(*session)))
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Started.Before(sessions[j].Started)
+	})
+	return sessions
+}
+
+// Session returns the active session with the given ID
+func (s *Server) Session(id string) (SessionInfo, bool) {
+	value, ok := s.connections.Load(id)
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return s.sessionInfo(value.(*session)), true
+}
+
+// sessionInfo takes a snapshot of the session, with its place in the waiting room
+func (s *Server) sessionInfo(sess *session) SessionInfo {
+	info := sess.info()
+	info.QueuePosition = s.admission.position(sess.connID)
+	return info
+}
+
+// TerminateSession ends an active session, the client receives a terminated error.
+// Returns false if no such session exists.
+func (s *Server) TerminateSession(id string) bool {
+	value, ok := s.connections.Load(id)
+	if !ok {
+		return false
+	}
+
+	s.log(Notice, "litmus session terminated", Entry{"connID", id})
+	s.finishTest(value.(*session), TestResult{}, FailureTerminated)
+	value.(*session).terminate(protocolErrorf(CodeTerminated, "session terminated by the server"))
+	return true
+}
+
+// RegisterAdminHandlers adds the session inspection and metrics endpoints to mux, under the same path base as RegisterHandlers.
+// They are not authenticated, only expose them on an internal listener or behind access control.
+//
+//	GET    /litmus/metrics              Prometheus metrics
+//	GET    /litmus/admin/sessions       active sessions
+//	GET    /litmus/admin/sessions/{id}  a single session
+//	DELETE /litmus/admin/sessions/{id}  terminate a session
+//	GET    /litmus/admin/sessions/{id}/timeline  timeline recorded so far, as CSV with format=csv
+//	GET    /litmus/admin/results        stored results, filtered by the remote_addr, since (RFC 3339) and limit query parameters
+func (s *Server) RegisterAdminHandlers(mux *http.ServeMux, pathBase string) {
+	mux.HandleFunc("GET "+litmusPath(pathBase)+"/metrics", s.metricsHandler)
+
+	path := litmusPath(pathBase) + "/admin/sessions"
+
+	mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, s.Sessions())
+	})
+
+	mux.HandleFunc("GET "+path+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+		info, ok := s.Session(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		writeAdminJSON(w, info)
+	})
+
+	mux.HandleFunc("GET "+path+"/{id}/timeline", func(w http.ResponseWriter, r *http.Request) {
+		timeline, ok := s.SessionTimeline(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			timeline.WriteCSV(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		timeline.WriteJSON(w)
+	})
+
+	mux.HandleFunc("DELETE "+path+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.TerminateSession(r.PathValue("id")) {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET "+litmusPath(pathBase)+"/admin/results", func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseResultQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, err := s.Results(r.Context(), q)
+		if errors.Is(err, ErrNoResultStore) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.err(Error, "litmus results query failed", err)
+			http.Error(w, "results query failed", http.StatusInternalServerError)
+			return
+		}
+		writeAdminJSON(w, results)
+	})
+}
+
+// parseResultQuery reads a ResultQuery from the URL query, limit defaults to defaultResultLimit
+func parseResultQuery(r *http.Request) (ResultQuery, error) {
+	values := r.URL.Query()
+	q := ResultQuery{
+		RemoteAddr: values.Get("remote_addr"),
+		Limit:      defaultResultLimit,
+	}
+
+	if since := values.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return q, fmt.Errorf("since: %w", err)
+		}
+		q.Since = t
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("limit must be a positive integer")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
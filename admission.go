@@ -0,0 +1,311 @@
+package litmus
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session rejection reasons, the reason label of litmus_sessions_rejected_total
+const (
+	RejectCapacity  = "capacity"   // the egress budget was exhausted and the waiting room, if any, was full
+	RejectRateLimit = "rate_limit" // the client IP reached one of its RateLimits, refused before the upgrade
+)
+
+// minRetryAfter is the shortest retry delay suggested to rejected clients
+const minRetryAfter = time.Second
+
+// Prioritizer assigns a priority class to a request, higher classes are admitted first from the waiting room.
+// It runs after authentication, so it may trust what the Authenticator checked.
+type Prioritizer func(r *http.Request) int
+
+// QueryPriority reads the priority class from an integer query parameter, 0 if absent or invalid.
+// The parameter can only be trusted if the URL is signed, see SignedURLs.
+func QueryPriority(param string) Prioritizer {
+	return func(r *http.Request) int {
+		priority, _ := strconv.Atoi(r.URL.Query().Get(param))
+		return priority
+	}
+}
+
+type reservation struct {
+	bitrate int       // kbps
+	expires time.Time // the test is cut off by then at the latest
+}
+
+// ticket is the place of a session in the waiting room
+type ticket struct {
+	connID   string
+	bitrate  int
+	duration time.Duration
+	priority int
+	arrival  uint64
+	notify   func(position, waiting int) // called with the 1 based position whenever it changes
+
+	done     chan struct{} // closed once the ticket is admitted or dropped
+	admitted bool          // set before done is closed
+	position int
+}
+
+// admission keeps the sum of the maximum bitrates of concurrent download tests within an egress budget.
+// Sessions that do not fit can wait in a waiting room of limited size, ordered by priority then arrival.
+// A nil admission admits every session.
+type admission struct {
+	budget    int // kbps
+	queueSize int // 0 disables the waiting room
+
+	mu           sync.Mutex
+	reserved     int                    // kbps
+	reservations map[string]reservation // by connection ID
+	waiting      []*ticket              // in admission order
+	arrivals     uint64
+}
+
+func newAdmission(budget, queueSize int) *admission {
+	if budget <= 0 {
+		return nil
+	}
+	return &admission{
+		budget:       budget,
+		queueSize:    queueSize,
+		reservations: make(map[string]reservation),
+	}
+}
+
+// reserve sets aside bitrate for a test lasting at most duration.
+// A bitrate above the whole budget is reserved as the whole budget, so that the test can run on an idle server.
+// If the budget is exhausted, or sessions are already waiting, returns false and how long until the earliest
+// reservation is released at the latest.
+func (a *admission) reserve(connID string, bitrate int, duration time.Duration) (time.Duration, bool) {
+	if a == nil {
+		return 0, true
+	}
+	bitrate = a.clamp(bitrate)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.reservations[connID]; ok {
+		return 0, true
+	}
+
+	if a.reserved+bitrate > a.budget || len(a.waiting) > 0 {
+		return a.retryAfter(), false
+	}
+	a.reserveLocked(connID, bitrate, duration)
+	return 0, true
+}
+
+// enqueue puts a session in the waiting room, returns false if it is disabled or full
+func (a *admission) enqueue(connID string, bitrate int, duration time.Duration, priority int, notify func(position, waiting int)) (*ticket, bool) {
+	if a == nil || a.queueSize <= 0 {
+		return nil, false
+	}
+
+	a.mu.Lock()
+	if len(a.waiting) >= a.queueSize {
+		a.mu.Unlock()
+		return nil, false
+	}
+
+	a.arrivals++
+	t := &ticket{
+		connID:   connID,
+		bitrate:  a.clamp(bitrate),
+		duration: duration,
+		priority: priority,
+		arrival:  a.arrivals,
+		notify:   notify,
+		done:     make(chan struct{}),
+	}
+	a.waiting = append(a.waiting, t)
+	sort.SliceStable(a.waiting, func(i, j int) bool {
+		if a.waiting[i].priority != a.waiting[j].priority {
+			return a.waiting[i].priority > a.waiting[j].priority
+		}
+		return a.waiting[i].arrival < a.waiting[j].arrival
+	})
+	a.promoteLocked()
+	notifications := a.positionsLocked()
+	a.mu.Unlock()
+
+	notifications()
+	return t, true
+}
+
+// release returns the reservation of a session to the budget, or takes it out of the waiting room,
+// then admits waiting sessions that fit
+func (a *admission) release(connID string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	if r, ok := a.reservations[connID]; ok {
+		delete(a.reservations, connID)
+		a.reserved -= r.bitrate
+	}
+	for i, t := range a.waiting {
+		if t.connID == connID {
+			a.waiting = append(a.waiting[:i], a.waiting[i+1:]...)
+			close(t.done)
+			break
+		}
+	}
+	a.promoteLocked()
+	notifications := a.positionsLocked()
+	a.mu.Unlock()
+
+	notifications()
+}
+
+// dropWaiting empties the waiting room without admitting anyone
+func (a *admission) dropWaiting() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range a.waiting {
+		close(t.done)
+	}
+	a.waiting = nil
+}
+
+// promoteLocked admits waiting sessions in order, as long as the first one fits
+func (a *admission) promoteLocked() {
+	for len(a.waiting) > 0 {
+		t := a.waiting[0]
+		if a.reserved+t.bitrate > a.budget {
+			return
+		}
+		a.waiting = a.waiting[1:]
+		a.reserveLocked(t.connID, t.bitrate, t.duration)
+		t.admitted = true
+		close(t.done)
+	}
+}
+
+// positionsLocked records the positions of waiting sessions and returns a function notifying those that moved,
+// to be called once the lock is released
+func (a *admission) positionsLocked() func() {
+	type move struct {
+		t        *ticket
+		position int
+	}
+	var moves []move
+	for i, t := range a.waiting {
+		if t.position != i+1 {
+			t.position = i + 1
+			moves = append(moves, move{t, t.position})
+		}
+	}
+	waiting := len(a.waiting)
+
+	return func() {
+		for _, m := range moves {
+			m.t.notify(m.position, waiting)
+		}
+	}
+}
+
+func (a *admission) reserveLocked(connID string, bitrate int, duration time.Duration) {
+	a.reservations[connID] = reservation{
+		bitrate: bitrate,
+		expires: time.Now().Add(duration),
+	}
+	a.reserved += bitrate
+}
+
+// retryAfter returns how long until the earliest reservation is released at the latest
+func (a *admission) retryAfter() time.Duration {
+	now := time.Now()
+	retryAfter := time.Duration(0)
+	for _, r := range a.reservations {
+		if wait := r.expires.Sub(now); retryAfter == 0 || wait < retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter < minRetryAfter {
+		retryAfter = minRetryAfter
+	}
+	return retryAfter
+}
+
+func (a *admission) clamp(bitrate int) int {
+	if bitrate > a.budget {
+		return a.budget
+	}
+	return bitrate
+}
+
+// position returns the 1 based place of a session in the waiting room, 0 if it is not waiting
+func (a *admission) position(connID string) int {
+	if a == nil {
+		return 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, t := range a.waiting {
+		if t.connID == connID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// usage returns the reserved and total egress in kbps, and the number of waiting sessions
+func (a *admission) usage() (reserved, budget, waiting int) {
+	if a == nil {
+		return 0, 0, 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reserved, a.budget, len(a.waiting)
+}
+
+// prioritize returns the waiting room class of a request
+func (s *Server) prioritize(r *http.Request) int {
+	if s.config.Prioritize == nil {
+		return 0
+	}
+	return s.config.Prioritize(r)
+}
+
+// admit reserves egress for the download phase of the test requested by an offer.
+// Upload only tests send nothing but signaling and are always admitted.
+//
+// If the budget is exhausted, sessions that negotiated the queue feature get a ticket to wait for their turn,
+// its done channel is closed once admitted or dropped. The others, and everyone once the waiting room is full,
+// are rejected: ok is false and retryAfter tells when to try again.
+func (s *Server) admit(sess *session, config Config, direction Direction) (t *ticket, retryAfter time.Duration, ok bool) {
+	if !direction.includesDownload() {
+		return nil, 0, true
+	}
+
+	retryAfter, ok = s.admission.reserve(sess.connID, config.MaxBitrate, config.MaxTestDuration)
+	if ok {
+		return nil, 0, true
+	}
+
+	if sess.hasFeature(FeatureQueue) {
+		notify := func(position, waiting int) {
+			sess.writeJSON(QueuePositionMessage{
+				Type:     MessageQueuePosition,
+				Position: position,
+				Waiting:  waiting,
+			})
+		}
+		if t, ok := s.admission.enqueue(sess.connID, config.MaxBitrate, config.MaxTestDuration, sess.priority, notify); ok {
+			return t, 0, true
+		}
+	}
+
+	s.metrics.sessionRejected(RejectCapacity)
+	return nil, retryAfter, false
+}
@@ -0,0 +1,194 @@
+package litmus
+
+import (
+	"testing"
+	"time"
+)
+
+const testDuration = 30 * time.Second
+
+// ticketState returns "waiting", "admitted" or "dropped"
+func ticketState(t *ticket) string {
+	select {
+	case <-t.done:
+		if t.admitted {
+			return "admitted"
+		}
+		return "dropped"
+	default:
+		return "waiting"
+	}
+}
+
+// queuePositions records the notifications of waiting sessions
+type queuePositions map[string][2]int
+
+func (p queuePositions) notify(connID string) func(position, waiting int) {
+	return func(position, waiting int) {
+		p[connID] = [2]int{position, waiting}
+	}
+}
+
+func mustEnqueue(t *testing.T, a *admission, connID string, bitrate, priority int, positions queuePositions) *ticket {
+	t.Helper()
+	ticket, ok := a.enqueue(connID, bitrate, testDuration, priority, positions.notify(connID))
+	if !ok {
+		t.Fatalf("%s not enqueued", connID)
+	}
+	return ticket
+}
+
+func TestAdmissionBudget(t *testing.T) {
+	if newAdmission(0, 10) != nil {
+		t.Fatal("admission without a budget")
+	}
+
+	a := newAdmission(10000, 0)
+	for _, connID := range []string{"a", "b"} {
+		if _, ok := a.reserve(connID, 4000, testDuration); !ok {
+			t.Fatalf("%s rejected within the budget", connID)
+		}
+	}
+	if _, ok := a.reserve("a", 4000, testDuration); !ok {
+		t.Error("a second reservation of the same session was rejected")
+	}
+
+	retryAfter, ok := a.reserve("c", 4000, testDuration)
+	if ok {
+		t.Fatal("c admitted past the budget")
+	}
+	if retryAfter < testDuration-time.Second || retryAfter > testDuration {
+		t.Errorf("retry after %s, want about %s", retryAfter, testDuration)
+	}
+	if _, ok := a.reserve("c", 2000, testDuration); !ok {
+		t.Error("c rejected while fitting the rest of the budget")
+	}
+	if reserved, budget, _ := a.usage(); reserved != 10000 || budget != 10000 {
+		t.Errorf("%d of %d kbps reserved, want all 10000", reserved, budget)
+	}
+
+	a.release("a")
+	a.release("a")
+	if reserved, _, _ := a.usage(); reserved != 6000 {
+		t.Errorf("%d kbps reserved after releasing a, want 6000", reserved)
+	}
+	if _, ok := a.reserve("d", 4000, testDuration); !ok {
+		t.Error("d rejected once a was released")
+	}
+
+	// a test asking for more than the whole budget still runs on an idle server
+	idle := newAdmission(5000, 0)
+	if _, ok := idle.reserve("large", 8000, testDuration); !ok {
+		t.Fatal("a test above the budget was rejected by an idle server")
+	}
+	if reserved, _, _ := idle.usage(); reserved != 5000 {
+		t.Errorf("%d kbps reserved, want the 5000 of the budget", reserved)
+	}
+	if retryAfter, ok := idle.reserve("small", 1, testDuration); ok || retryAfter < minRetryAfter {
+		t.Errorf("small admitted %t, retry after %s", ok, retryAfter)
+	}
+}
+
+func TestAdmissionPriority(t *testing.T) {
+	a := newAdmission(1000, 4)
+	a.reserve("running", 1000, testDuration)
+
+	positions := queuePositions{}
+	first := mustEnqueue(t, a, "first", 1000, 0, positions)
+	second := mustEnqueue(t, a, "second", 1000, 0, positions)
+	urgent := mustEnqueue(t, a, "urgent", 1000, 1, positions)
+
+	want := queuePositions{"urgent": {1, 3}, "first": {2, 3}, "second": {3, 3}}
+	for connID, position := range want {
+		if positions[connID] != position {
+			t.Errorf("%s notified at %v, want %v", connID, positions[connID], position)
+		}
+		if got := a.position(connID); got != position[0] {
+			t.Errorf("%s at position %d, want %d", connID, got, position[0])
+		}
+	}
+
+	a.release("running")
+
+	order := []*ticket{urgent, first, second}
+	for i, current := range order {
+		for j, ticket := range order {
+			want := "waiting"
+			if j <= i {
+				want = "admitted"
+			}
+			if state := ticketState(ticket); state != want {
+				t.Errorf("after %d releases, %s is %s, want %s", i+1, ticket.connID, state, want)
+			}
+		}
+		a.release(current.connID)
+	}
+	if positions["second"] != [2]int{1, 1} {
+		t.Errorf("second last notified at %v, want first of 1", positions["second"])
+	}
+}
+
+func TestAdmissionQueueFull(t *testing.T) {
+	closed := newAdmission(1000, 0)
+	closed.reserve("running", 1000, testDuration)
+	if _, ok := closed.enqueue("waiting", 1000, testDuration, 0, func(int, int) {}); ok {
+		t.Error("enqueued without a waiting room")
+	}
+
+	a := newAdmission(1000, 2)
+	a.reserve("running", 1000, testDuration)
+	positions := queuePositions{}
+	mustEnqueue(t, a, "first", 1000, 0, positions)
+	mustEnqueue(t, a, "second", 1000, 0, positions)
+	if _, ok := a.enqueue("third", 1000, testDuration, 5, positions.notify("third")); ok {
+		t.Error("enqueued in a full waiting room, priority notwithstanding")
+	}
+	if _, _, waiting := a.usage(); waiting != 2 {
+		t.Errorf("%d waiting, want 2", waiting)
+	}
+}
+
+func TestAdmissionPromotion(t *testing.T) {
+	a := newAdmission(3000, 4)
+	a.reserve("large", 2000, testDuration)
+	a.reserve("small", 1000, testDuration)
+
+	positions := queuePositions{}
+	wide := mustEnqueue(t, a, "wide", 2000, 0, positions)
+	narrow := mustEnqueue(t, a, "narrow", 1000, 0, positions)
+
+	// narrow would fit, but waits behind wide
+	a.release("small")
+	if ticketState(wide) != "waiting" || ticketState(narrow) != "waiting" {
+		t.Fatalf("wide %s and narrow %s, want both waiting", ticketState(wide), ticketState(narrow))
+	}
+	// and so would newcomers, the waiting room is served first
+	if _, ok := a.reserve("late", 1000, testDuration); ok {
+		t.Error("late admitted past the waiting room")
+	}
+
+	a.release("large")
+	if ticketState(wide) != "admitted" || ticketState(narrow) != "admitted" {
+		t.Fatalf("wide %s and narrow %s, want both admitted", ticketState(wide), ticketState(narrow))
+	}
+	if reserved, _, waiting := a.usage(); reserved != 3000 || waiting != 0 {
+		t.Errorf("%d kbps reserved and %d waiting, want 3000 and 0", reserved, waiting)
+	}
+
+	// leaving the waiting room moves the others up
+	left := mustEnqueue(t, a, "left", 1000, 0, positions)
+	stayed := mustEnqueue(t, a, "stayed", 1000, 0, positions)
+	a.release("left")
+	if ticketState(left) != "dropped" || positions["stayed"] != [2]int{1, 1} {
+		t.Errorf("left %s, stayed notified at %v", ticketState(left), positions["stayed"])
+	}
+
+	// shutting down drops everyone still waiting
+	a.dropWaiting()
+	if ticketState(stayed) != "dropped" {
+		t.Errorf("stayed %s after dropWaiting", ticketState(stayed))
+	}
+	if _, _, waiting := a.usage(); waiting != 0 {
+		t.Errorf("%d waiting after dropWaiting", waiting)
+	}
+}
@@ -0,0 +1,122 @@
+package litmus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/blitz-frost/log"
+)
+
+var (
+	// ErrUnauthorized rejects a request without credentials, answered with 401
+	ErrUnauthorized = errors.New("authentication required")
+	// ErrForbidden rejects a request with invalid credentials, answered with 403
+	ErrForbidden = errors.New("access denied")
+)
+
+// Authenticator decides whether a request may open a litmus session, before the websocket upgrade.
+// It returns nil to accept the request. Errors wrapping ErrForbidden are answered with 403, any other error with 401.
+type Authenticator func(r *http.Request) error
+
+// BearerTokens accepts requests carrying one of tokens, either as an "Authorization: Bearer" header
+// or, since browsers cannot set headers on websockets, as an access_token query parameter
+func BearerTokens(tokens ...string) Authenticator {
+	return func(r *http.Request) error {
+		presented := r.URL.Query().Get("access_token")
+		if header := r.Header.Get("Authorization"); header != "" {
+			scheme, token, ok := strings.Cut(header, " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") {
+				return ErrForbidden
+			}
+			presented = token
+		}
+		if presented == "" {
+			return ErrUnauthorized
+		}
+
+		for _, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+				return nil
+			}
+		}
+		return ErrForbidden
+	}
+}
+
+// SignedURLs accepts requests whose URL was signed by SignURL with key and has not expired.
+// The signature covers the path and every other query parameter.
+func SignedURLs(key []byte) Authenticator {
+	return func(r *http.Request) error {
+		query := r.URL.Query()
+		signature := query.Get("signature")
+		if signature == "" {
+			return ErrUnauthorized
+		}
+		query.Del("signature")
+
+		presented, err := base64.RawURLEncoding.DecodeString(signature)
+		if err != nil || !hmac.Equal(presented, urlSignature(key, r.URL.Path, query)) {
+			return ErrForbidden
+		}
+
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err != nil || !time.Now().Before(time.Unix(expires, 0)) {
+			return ErrForbidden
+		}
+		return nil
+	}
+}
+
+// SignURL adds an expires and a signature query parameter to rawURL, for servers authenticating with SignedURLs and key.
+// Query parameters already present are signed too.
+func SignURL(key []byte, rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Del("signature")
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", base64.RawURLEncoding.EncodeToString(urlSignature(key, u.Path, query)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// urlSignature signs a path and its query, without the signature itself
+func urlSignature(key []byte, path string, query url.Values) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return mac.Sum(nil)
+}
+
+// authenticate runs the configured Authenticator, answering the request itself if it is rejected
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if s.config.Authenticate == nil {
+		return true
+	}
+
+	err := s.config.Authenticate(r)
+	if err == nil {
+		return true
+	}
+
+	s.log(Warning, "litmus connection refused",
+		Entry{"remoteAddr", r.RemoteAddr},
+		Entry{"error", err})
+	if errors.Is(err, ErrForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="litmus"`)
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+	return false
+}
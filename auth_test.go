@@ -0,0 +1,125 @@
+package litmus
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/blitz-frost/log"
+)
+
+// authMux serves the litmus endpoint behind a, without logs
+func authMux(a Authenticator) *http.ServeMux {
+	mux := http.NewServeMux()
+	NewServer(0, WithAuthenticator(a), WithLogger(LineLoggerMake(io.Discard, nil))).RegisterHandlers(mux, "")
+	return mux
+}
+
+// accepted requests go on to the websocket upgrade, which plain GETs fail with 400
+const statusAuthenticated = http.StatusBadRequest
+
+func TestBearerTokens(t *testing.T) {
+	mux := authMux(BearerTokens("first", "second"))
+
+	tests := []struct {
+		name          string
+		target        string
+		authorization string
+		status        int
+	}{
+		{"header", "/litmus", "Bearer second", statusAuthenticated},
+		{"scheme is case insensitive", "/litmus", "bearer first", statusAuthenticated},
+		{"query", "/litmus?access_token=first", "", statusAuthenticated},
+		{"missing", "/litmus", "", http.StatusUnauthorized},
+		{"empty query", "/litmus?access_token=", "", http.StatusUnauthorized},
+		{"wrong header", "/litmus", "Bearer third", http.StatusForbidden},
+		{"wrong query", "/litmus?access_token=third", "", http.StatusForbidden},
+		{"other scheme", "/litmus", "Basic Zmlyc3Q6", http.StatusForbidden},
+		{"header takes precedence", "/litmus?access_token=first", "Bearer third", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); (w.Code == http.StatusUnauthorized) != (challenge != "") {
+				t.Errorf("status %d with WWW-Authenticate %q", w.Code, challenge)
+			}
+		})
+	}
+}
+
+func TestSignedURLs(t *testing.T) {
+	key := []byte("url signing key")
+	mux := authMux(SignedURLs(key))
+
+	expires := time.Now().Add(time.Minute)
+	valid := mustSignURL(t, key, "/litmus?room=a", expires)
+
+	// a signature made for another path
+	u, err := url.Parse(mustSignURL(t, key, "/rooms/a/litmus", expires))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPath := "/litmus?" + u.RawQuery
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"valid", valid, statusAuthenticated},
+		{"unsigned", "/litmus?room=a", http.StatusUnauthorized},
+		{"bad signature", withQuery(t, valid, "signature", base64.RawURLEncoding.EncodeToString(make([]byte, sha256.Size))), http.StatusForbidden},
+		{"undecodable signature", withQuery(t, valid, "signature", "!!"), http.StatusForbidden},
+		{"other key", mustSignURL(t, []byte("another key"), "/litmus?room=a", expires), http.StatusForbidden},
+		// every query parameter is signed, the expiry included
+		{"changed parameter", withQuery(t, valid, "room", "b"), http.StatusForbidden},
+		{"extended expiry", withQuery(t, valid, "expires", "99999999999"), http.StatusForbidden},
+		{"expired", mustSignURL(t, key, "/litmus?room=a", time.Now().Add(-time.Second)), http.StatusForbidden},
+		{"path mismatch", otherPath, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func mustSignURL(t *testing.T, key []byte, rawURL string, expires time.Time) string {
+	t.Helper()
+	signed, err := SignURL(key, rawURL, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// withQuery sets a query parameter of rawURL
+func withQuery(t *testing.T, rawURL, key, value string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,73 @@
+package litmus
+
+// Bufferbloat grades, from best to worst
+const (
+	GradeAPlus = "A+"
+	GradeA     = "A"
+	GradeB     = "B"
+	GradeC     = "C"
+	GradeD     = "D"
+	GradeF     = "F"
+)
+
+// bufferbloatGrades maps the upper bound of added latency (ms) to a grade and the share of the measured bitrate
+// still usable for real time video at that grade
+var bufferbloatGrades = []struct {
+	maxAddedLatency float64
+	grade           string
+	usableRatio     float64
+}{
+	{5, GradeAPlus, 1.0},
+	{30, GradeA, 1.0},
+	{60, GradeB, 0.95},
+	{200, GradeC, 0.85},
+	{400, GradeD, 0.7},
+}
+
+const gradeFUsableRatio = 0.5
+
+// Bufferbloat describes how much queueing delay the link adds once it is loaded
+type Bufferbloat struct {
+	Grade        string  `json:"grade"`            // empty if RTT was not measured
+	AddedLatency float64 `json:"added_latency_ms"` // average loaded RTT minus average idle RTT
+}
+
+// GradeBufferbloat compares loaded RTT to idle RTT
+func GradeBufferbloat(idle, loaded RTTStats) Bufferbloat {
+	if idle.Samples == 0 || loaded.Samples == 0 {
+		return Bufferbloat{}
+	}
+
+	added := loaded.Avg - idle.Avg
+	if added < 0 {
+		added = 0
+	}
+
+	for _, g := range bufferbloatGrades {
+		if added < g.maxAddedLatency {
+			return Bufferbloat{Grade: g.grade, AddedLatency: added}
+		}
+	}
+	return Bufferbloat{Grade: GradeF, AddedLatency: added}
+}
+
+// UsableRatio returns the share of the measured bitrate that should be relied on given the grade
+func (b Bufferbloat) UsableRatio() float64 {
+	if b.Grade == "" {
+		return 1
+	}
+	for _, g := range bufferbloatGrades {
+		if b.Grade == g.grade {
+			return g.usableRatio
+		}
+	}
+	return gradeFUsableRatio
+}
+
+// worse returns whichever grade shows more added latency
+func (b Bufferbloat) worse(o Bufferbloat) Bufferbloat {
+	if o.Grade != "" && (b.Grade == "" || o.AddedLatency > b.AddedLatency) {
+		return o
+	}
+	return b
+}
@@ -0,0 +1,375 @@
+// Package client runs litmus network tests without a browser.
+//
+// It performs the same signaling as the JS ConnectionManager against a litmus server's /litmus endpoint,
+// measures the received test stream the same way as MetricsManager.js and returns a typed Result.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kickback-space/litmus"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	packetSize     = 1200 // bytes, matches the server stream
+	sendTick       = 10 * time.Millisecond
+	maxSendBacklog = 250 * time.Millisecond // of packets at the current bitrate, queued in the data channel at most
+	reportInterval = 200 * time.Millisecond // matches the server adapt interval
+)
+
+var (
+	ErrClosed     = errors.New("litmus server closed the connection before the test completed")
+	ErrPeerFailed = errors.New("webrtc connection failed")
+)
+
+// Result is the outcome of a test, as reported by the server's test_complete message
+type Result = litmus.TestReport
+
+// Client runs tests against litmus servers
+type Client struct {
+	direction       litmus.Direction
+	iceServers      []webrtc.ICEServer
+	header          http.Header
+	dialer          *websocket.Dialer
+	onBitrateUpdate func(direction litmus.Direction, bitrate int)
+	onQueuePosition func(position, waiting int)
+	parameters      *litmus.TestParameters
+	api             *webrtc.API
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithDirection selects which direction(s) to test, download by default
+func WithDirection(d litmus.Direction) Option {
+	return func(c *Client) {
+		c.direction = d
+	}
+}
+
+// WithICEServers sets the ICE servers of the client peer connection
+func WithICEServers(servers ...webrtc.ICEServer) Option {
+	return func(c *Client) {
+		c.iceServers = servers
+	}
+}
+
+// WithHeader sets extra HTTP headers sent with the websocket handshake
+func WithHeader(h http.Header) Option {
+	return func(c *Client) {
+		c.header = h
+	}
+}
+
+// WithDialer sets the websocket dialer, for example to configure TLS
+func WithDialer(d *websocket.Dialer) Option {
+	return func(c *Client) {
+		c.dialer = d
+	}
+}
+
+// WithParameters requests test parameters from the server, which clamps them to its own limits
+func WithParameters(p litmus.TestParameters) Option {
+	return func(c *Client) {
+		c.parameters = &p
+	}
+}
+
+// WithAPI sets the pion API the peer connection is created with, for example to run on a virtual network
+func WithAPI(api *webrtc.API) Option {
+	return func(c *Client) {
+		c.api = api
+	}
+}
+
+// WithBitrateUpdate registers a callback invoked on every bitrate_update message
+func WithBitrateUpdate(f func(direction litmus.Direction, bitrate int)) Option {
+	return func(c *Client) {
+		c.onBitrateUpdate = f
+	}
+}
+
+// WithQueuePosition registers a callback invoked on every queue_position message,
+// while the test waits for capacity on a server with a waiting room
+func WithQueuePosition(f func(position, waiting int)) Option {
+	return func(c *Client) {
+		c.onQueuePosition = f
+	}
+}
+
+// New creates a Client
+func New(opts ...Option) *Client {
+	c := &Client{
+		direction: litmus.DirectionDownload,
+		iceServers: []webrtc.ICEServer{
+			{
+				URLs: []string{"stun:stun.l.google.com:19302"},
+			},
+		},
+		dialer: websocket.DefaultDialer,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run performs a single test against the websocket url of a litmus endpoint, such as ws://localhost:8000/litmus.
+// It blocks until the server reports the result, the connection fails or ctx is done.
+func (c *Client) Run(ctx context.Context, url string) (*Result, error) {
+	ws, resp, err := c.dialer.DialContext(ctx, url, c.header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			// refused by a rate limit before the upgrade, reported like the in-band rate_limited error
+			retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			return nil, &litmus.ProtocolError{
+				Code:       litmus.CodeRateLimited,
+				Message:    resp.Status,
+				RetryAfter: time.Duration(retryAfter) * time.Second,
+			}
+		}
+		if resp != nil {
+			return nil, fmt.Errorf("%w: %s", err, resp.Status)
+		}
+		return nil, err
+	}
+	defer ws.Close()
+
+	var wsWriteMutex sync.Mutex
+	writeJSON := func(v interface{}) error {
+		wsWriteMutex.Lock()
+		defer wsWriteMutex.Unlock()
+		return ws.WriteJSON(v)
+	}
+
+	configuration := webrtc.Configuration{
+		ICEServers: c.iceServers,
+	}
+	newPeerConnection := webrtc.NewPeerConnection
+	if c.api != nil {
+		newPeerConnection = c.api.NewPeerConnection
+	}
+	peerConnection, err := newPeerConnection(configuration)
+	if err != nil {
+		return nil, err
+	}
+	defer peerConnection.Close()
+
+	ordered := false
+	maxRetransmits := uint16(0)
+	dc, err := peerConnection.CreateDataChannel("networkTest", &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case failed <- err:
+		default:
+		}
+		ws.Close() // unblocks the read loop
+	}
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed {
+			fail(ErrPeerFailed)
+		}
+	})
+
+	metrics := litmus.NewReceiverMetrics(reportInterval)
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		m, ok := metrics.Observe(msg.Data, time.Now())
+		if !ok {
+			return
+		}
+		if err := writeJSON(litmus.MetricsReportMessage{
+			Type:             litmus.MessageMetricsReport,
+			LossRate:         m.LossRate,
+			Jitter:           m.Jitter,
+			ActualThroughput: m.ActualThroughput,
+		}); err != nil {
+			fail(err)
+		}
+	})
+
+	// Echo RTT probes back to the server as they arrive
+	peerConnection.OnDataChannel(func(probes *webrtc.DataChannel) {
+		if probes.Label() != "rtt" {
+			return
+		}
+		probes.OnMessage(func(msg webrtc.DataChannelMessage) {
+			probes.Send(msg.Data)
+		})
+	})
+
+	uploader := newSender(dc)
+	defer uploader.stop()
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return nil, err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		return nil, err
+	}
+
+	// Candidates are embedded in the offer, the server may not accept trickled candidates before it
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// The offer is sent once the server answered the hello, so that it only carries what the server supports
+	if err := writeJSON(litmus.HelloMessage{
+		Type:     litmus.MessageHello,
+		Version:  litmus.ProtocolVersion,
+		Features: []string{litmus.FeatureUpload, litmus.FeatureRTT, litmus.FeatureParameters, litmus.FeatureQueue},
+	}); err != nil {
+		return nil, err
+	}
+
+	sendOffer := func(features []string) error {
+		offer := litmus.OfferMessage{
+			Type:      litmus.MessageOffer,
+			SDP:       peerConnection.LocalDescription().SDP,
+			Direction: c.direction,
+		}
+		for _, feature := range features {
+			if feature == litmus.FeatureParameters && c.parameters != nil {
+				offer.Parameters = c.parameters
+			}
+		}
+		return writeJSON(offer)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		fail(ctx.Err())
+	})
+	defer stop()
+
+	greeted := false
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			select {
+			case err := <-failed:
+				return nil, err
+			default:
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil, ErrClosed
+			}
+			return nil, err
+		}
+
+		var envelope struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, err
+		}
+
+		switch envelope.Type {
+		case litmus.MessageHello:
+			var msg litmus.HelloMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, err
+			}
+			greeted = true
+			if err := sendOffer(msg.Features); err != nil {
+				return nil, err
+			}
+
+		case litmus.MessageAnswer:
+			var msg litmus.AnswerMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, err
+			}
+			if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{
+				Type: webrtc.SDPTypeAnswer,
+				SDP:  msg.SDP,
+			}); err != nil {
+				return nil, err
+			}
+
+		case litmus.MessageCandidate:
+			var msg litmus.CandidateMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, err
+			}
+			if msg.Candidate.Candidate == "" {
+				continue
+			}
+			if err := peerConnection.AddICECandidate(msg.Candidate); err != nil {
+				return nil, err
+			}
+
+		case litmus.MessageBitrateUpdate:
+			var msg litmus.BitrateUpdateMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, err
+			}
+			if msg.Direction == litmus.DirectionUpload {
+				if msg.Final {
+					uploader.stop()
+				} else {
+					uploader.start(msg.Bitrate)
+				}
+			}
+			if c.onBitrateUpdate != nil {
+				c.onBitrateUpdate(msg.Direction, msg.Bitrate)
+			}
+
+		case litmus.MessageQueuePosition:
+			var msg litmus.QueuePositionMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, err
+			}
+			if c.onQueuePosition != nil {
+				c.onQueuePosition(msg.Position, msg.Waiting)
+			}
+
+		case litmus.MessageTestComplete:
+			var msg litmus.TestCompleteMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, err
+			}
+			uploader.stop()
+			return &msg.TestReport, nil
+
+		case litmus.MessageError:
+			var msg litmus.ErrorMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, err
+			}
+			// Servers predating the hello handshake reject it, the offer still goes through
+			if !greeted && msg.Code == litmus.CodeUnknownType {
+				greeted = true
+				if err := sendOffer(nil); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, &litmus.ProtocolError{
+				Code:       msg.Code,
+				Message:    msg.Message,
+				RetryAfter: time.Duration(msg.RetryAfter) * time.Second,
+			}
+		}
+	}
+}
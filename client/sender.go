@@ -0,0 +1,91 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kickback-space/litmus"
+	"github.com/pion/webrtc/v3"
+)
+
+// sender streams test packets to the server during the upload phase, paced to the bitrate requested by the server
+type sender struct {
+	dc *webrtc.DataChannel
+
+	mu       sync.Mutex
+	bitrate  int // kbps
+	sequence uint32
+	done     chan struct{} // nil when not running
+}
+
+func newSender(dc *webrtc.DataChannel) *sender {
+	return &sender{
+		dc: dc,
+	}
+}
+
+func (s *sender) start(bitrate int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bitrate = bitrate
+	if s.done != nil {
+		return
+	}
+
+	s.done = make(chan struct{})
+	go s.run(s.done)
+}
+
+func (s *sender) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+}
+
+func (s *sender) run(done chan struct{}) {
+	ticker := time.NewTicker(sendTick)
+	defer ticker.Stop()
+
+	// Accumulate fractional packets so low bitrates are paced correctly
+	credit := 0.0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		bitrate := s.bitrate
+		s.mu.Unlock()
+
+		// the link does not keep up, packets are skipped rather than queued behind those waiting
+		if backlog := uint64(bitrate) * 1000 / 8 * uint64(maxSendBacklog.Milliseconds()) / 1000; s.dc.BufferedAmount() > backlog {
+			credit = 0
+			continue
+		}
+
+		packetsPerSecond := float64(bitrate*1000) / float64(packetSize*8)
+		credit += packetsPerSecond * sendTick.Seconds()
+
+		for ; credit >= 1; credit-- {
+			s.mu.Lock()
+			sequence := s.sequence
+			s.sequence++
+			s.mu.Unlock()
+
+			packet, err := litmus.NewTestPacket(packetSize, sequence, time.Now())
+			if err != nil {
+				return
+			}
+			if err := s.dc.Send(packet); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -9,7 +9,13 @@ class ConnectionManager {
     this.onStateChangeCallback = null;
     this.onTestCompleteCallback = null;
     this.onBitrateUpdateCallback = null;
+    this.onQueuePositionCallback = null;
     this.connectionState = 'disconnected';
+    this.direction = 'download';
+    this.parameters = null;
+    this.offerSent = false;
+    this.uploadSender = null;
+    this.protocol = { version: 1, features: [] };
   }
 
   sendMetricsReport(report) {
@@ -18,7 +24,10 @@ class ConnectionManager {
     }
   }
   
-  async connect(hostAddress, useSsl = false) {
+  async connect(hostAddress, useSsl = false, direction = 'download', parameters = null) {
+    this.direction = direction;
+    this.parameters = parameters;
+    this.offerSent = false;
     try {
       this.updateState('connecting');
       await this.setupPeerConnection();
@@ -54,8 +63,8 @@ class ConnectionManager {
 
     return new Promise((resolve, reject) => {
       this.webSocket.onopen = () => {
-        this.sendOffer();
-        this.sendPendingCandidates();
+        // The offer follows the server's hello reply, see handleWebSocketMessage
+        this.sendHello();
         resolve();
       };
 
@@ -78,7 +87,24 @@ class ConnectionManager {
   async handleWebSocketMessage(response) {
     try {
       switch (response.type) {
+        case 'hello':
+          this.protocol = { version: response.version, features: response.features || [] };
+          this.sendOffer();
+          this.sendPendingCandidates();
+          break;
+
+        case 'queue_position':
+          // The offer waits for capacity, the answer follows once the test is admitted
+          this.updateState('queued');
+          if (this.onQueuePositionCallback) {
+            this.onQueuePositionCallback(response.position, response.waiting);
+          }
+          break;
+
         case 'answer':
+          if (this.connectionState === 'queued') {
+            this.updateState('connecting');
+          }
           await this.peerConnection.setRemoteDescription(
             new RTCSessionDescription(response)
           );
@@ -91,17 +117,31 @@ class ConnectionManager {
           break;
 
         case 'bitrate_update':
+          if (response.direction === 'upload') {
+            this.handleUploadBitrate(response);
+          }
           if (this.onBitrateUpdateCallback) {
             this.onBitrateUpdateCallback(response.bitrate);
           }
           break;
 
         case 'test_complete':
+          this.stopUpload();
           if (this.onTestCompleteCallback) {
-            this.onTestCompleteCallback(response.bitrate);
+            this.onTestCompleteCallback(response);
           }
           break;
 
+        case 'error':
+          // Servers predating the hello handshake reject it, fall back to a plain offer
+          if (!this.offerSent && response.code === 'unknown_type') {
+            this.sendOffer();
+            this.sendPendingCandidates();
+            break;
+          }
+          console.error(`Server error (${response.code}):`, response.message);
+          break;
+
         default:
           console.warn('Unknown message type:', response.type);
       }
@@ -139,6 +179,19 @@ class ConnectionManager {
       }
     };
 
+    // Echo RTT probes back to the server as they arrive
+    this.peerConnection.ondatachannel = (event) => {
+      const channel = event.channel;
+      if (channel.label !== 'rtt') return;
+
+      channel.binaryType = 'arraybuffer';
+      channel.onmessage = (message) => {
+        if (channel.readyState === 'open') {
+          channel.send(message.data);
+        }
+      };
+    };
+
     this.peerConnection.onconnectionstatechange = () => {
       if (['disconnected', 'failed', 'closed'].includes(this.peerConnection.connectionState)) {
         this.disconnect();
@@ -152,19 +205,52 @@ class ConnectionManager {
       candidate: candidate.toJSON(),
     };
 
-    if (this.webSocket?.readyState === WebSocket.OPEN) {
+    if (this.offerSent && this.webSocket?.readyState === WebSocket.OPEN) {
       this.webSocket.send(JSON.stringify(candidateData));
     } else {
       this.pendingCandidates.push(candidateData);
     }
   }
 
+  handleUploadBitrate(response) {
+    if (response.final) {
+      this.stopUpload();
+      return;
+    }
+
+    if (!this.uploadSender) {
+      this.uploadSender = new UploadSender(this.dataChannel);
+    }
+    this.uploadSender.start(response.bitrate);
+  }
+
+  stopUpload() {
+    if (this.uploadSender) {
+      this.uploadSender.stop();
+      this.uploadSender = null;
+    }
+  }
+
+  sendHello() {
+    this.webSocket.send(JSON.stringify({
+      type: 'hello',
+      version: NetworkConfig.protocolVersion,
+      features: NetworkConfig.features,
+    }));
+  }
+
   sendOffer() {
     if (this.peerConnection.localDescription) {
-      this.webSocket.send(JSON.stringify({
+      const offer = {
         type: 'offer',
         sdp: this.peerConnection.localDescription.sdp,
-      }));
+        direction: this.direction,
+      };
+      if (this.parameters && this.protocol.features.includes('parameters')) {
+        offer.parameters = this.parameters;
+      }
+      this.webSocket.send(JSON.stringify(offer));
+      this.offerSent = true;
     }
   }
 
@@ -191,6 +277,8 @@ class ConnectionManager {
   }
 
   disconnect() {
+    this.stopUpload();
+
     if (this.dataChannel) {
       this.dataChannel.close();
       this.dataChannel = null;
@@ -222,6 +310,10 @@ class ConnectionManager {
     this.onBitrateUpdateCallback = callback;
   }
 
+  onQueuePosition(callback) {
+    this.onQueuePositionCallback = callback;
+  }
+
   onStateChange(callback) {
     this.onStateChangeCallback = callback;
   }
@@ -30,9 +30,13 @@ class NetworkTester {
 		this.connectionManager.onBirateUpdate((data) => {
 			this.onBirateUpdate(data);
 		})
+
+		this.connectionManager.onQueuePosition((position, waiting) => {
+			this.handleQueuePosition(position, waiting);
+		});
 	}
 
-	async startTest(hostAddress, useSsl = false) {
+	async startTest(hostAddress, useSsl = false, direction = 'download', parameters = null) {
 		if (this.isRunning) {
 			console.warn('Test is already running');
 			return;
@@ -48,7 +52,7 @@ class NetworkTester {
 			await this.metricsManager.detectNetworkCapabilities();
 
 			// Connect to the server
-			await this.connectionManager.connect(hostAddress, useSsl);
+			await this.connectionManager.connect(hostAddress, useSsl, direction, parameters);
 		} catch (error) {
 			console.error('Failed to start test:', error);
 			this.stopTest();
@@ -59,18 +63,41 @@ class NetworkTester {
 
 	handleTestComplete(result) {
 
-		const finalProfile = result || this.lastBirate || 'No birate available';
-		console.log('Test Complete! Final Bitrate:', result);
+		const finalBitrate = result.bitrate || this.lastBirate || 'No birate available';
+		const finalProfile = result.profile || 'No sustainable profile';
+		console.log('Test Complete! Final Bitrate:', finalBitrate, 'Profile:', finalProfile);
+		if (result.download) {
+			console.log('Download:', result.download);
+		}
+		if (result.upload) {
+			console.log('Upload:', result.upload);
+		}
+		if (result.idle_rtt && result.idle_rtt.samples > 0) {
+			console.log('RTT idle:', result.idle_rtt, 'under load:', result.loaded_rtt);
+		}
+		if (result.bufferbloat && result.bufferbloat.grade) {
+			console.log(`Bufferbloat grade ${result.bufferbloat.grade} (+${result.bufferbloat.added_latency_ms.toFixed(1)} ms under load)`);
+		}
+		(result.failed || []).forEach((f) => {
+			console.log(`Profile ${f.profile} failed:`, f.reasons.join(', '));
+		});
 		
 		const testCompleteElement = document.getElementById('testComplete');
 		if (testCompleteElement) {
-			testCompleteElement.textContent = `Test Complete! Final bitrate: ${result}`;
+			testCompleteElement.textContent = `Test Complete! Final bitrate: ${finalBitrate}, recommended profile: ${finalProfile}`;
 			testCompleteElement.style.display = 'block';
 		}
 
 		this.stopTest();
 	}
 
+	handleQueuePosition(position, waiting) {
+		const stateElement = document.getElementById('connectionState');
+		if (stateElement) {
+			stateElement.textContent = `Waiting for capacity, position ${position} of ${waiting}...`;
+		}
+	}
+
 	onBirateUpdate(data) {
 		this.lastBirate = data; 
 	}
@@ -94,6 +121,9 @@ class NetworkTester {
 				case 'connecting':
 					stateElement.textContent = 'Connecting...';
 					break;
+				case 'queued':
+					// the position is shown by handleQueuePosition
+					break;
 				case 'connected':
 					stateElement.textContent = 'Connected, receiving test packets...';
 					break;
@@ -128,9 +158,10 @@ document.addEventListener('DOMContentLoaded', () => {
 		startButton.addEventListener('click', async () => {
 			const hostAddress = document.getElementById('hostAddress').value;
 			const useSsl = document.getElementById('useSsl').checked;
+			const direction = document.getElementById('direction')?.value || 'download';
 			
 			try {
-				await tester.startTest(hostAddress, useSsl);
+				await tester.startTest(hostAddress, useSsl, direction);
 			} catch (error) {
 				console.error('Test failed:', error);
 			}
@@ -0,0 +1,60 @@
+// UploadSender.js
+class UploadSender {
+  constructor(dataChannel) {
+    this.dataChannel = dataChannel;
+    this.sequence = 0;
+    this.bitrate = 0;
+    this.timer = null;
+    this.packetSize = 1200; // bytes, matches the server stream
+    this.tickMs = 10;
+    this.maxBacklogMs = 250; // of packets at the current bitrate, queued in the data channel at most
+    this.credit = 0;
+  }
+
+  start(bitrate) {
+    this.setBitrate(bitrate);
+    if (this.timer) return;
+
+    this.timer = setInterval(() => this.sendTick(), this.tickMs);
+  }
+
+  setBitrate(bitrate) {
+    this.bitrate = bitrate; // kbps
+  }
+
+  sendTick() {
+    if (!this.dataChannel || this.dataChannel.readyState !== 'open') return;
+
+    // The link does not keep up, packets are skipped rather than queued behind those waiting
+    if (this.dataChannel.bufferedAmount > (this.bitrate * 1000 / 8) * this.maxBacklogMs / 1000) {
+      this.credit = 0;
+      return;
+    }
+
+    // Accumulate fractional packets so low bitrates are paced correctly
+    const packetsPerSecond = (this.bitrate * 1000) / (this.packetSize * 8);
+    this.credit += packetsPerSecond * this.tickMs / 1000;
+
+    while (this.credit >= 1) {
+      this.dataChannel.send(this.buildPacket());
+      this.credit -= 1;
+    }
+  }
+
+  buildPacket() {
+    const packet = new Uint8Array(this.packetSize);
+    const view = new DataView(packet.buffer);
+    view.setUint32(0, this.sequence++);
+    view.setBigUint64(4, BigInt(Date.now()) * 1000000n);
+    crypto.getRandomValues(packet.subarray(12));
+    return packet;
+  }
+
+  stop() {
+    if (this.timer) {
+      clearInterval(this.timer);
+      this.timer = null;
+    }
+    this.credit = 0;
+  }
+}
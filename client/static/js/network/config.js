@@ -11,7 +11,10 @@ const NetworkConfig = {
   dataChannelConfig: {
     ordered: false,
     maxRetransmits: 0,
-  }
+  },
+
+  protocolVersion: 2,
+  features: ['upload', 'rtt', 'parameters', 'queue'],
 };
 
 Object.freeze(NetworkConfig);
\ No newline at end of file
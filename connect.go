@@ -3,6 +3,7 @@ package litmus
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"sync"
 	"strconv"
@@ -16,19 +17,15 @@ import (
 
 var ErrConnectionFailed = errors.New("webrtc connection closed")
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) error {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		Log(Error, "network litmus websocket upgrade failed", Entry{"error", err})
+		s.log(Error, "network litmus websocket upgrade failed", Entry{"error", err})
 		return err
 	}
 	defer ws.Close()
+	// gorilla closes the connection with 1009 message too big past the limit, without buffering the rest
+	ws.SetReadLimit(MaxMessageSize)
 
 	var wsWriteMutex sync.Mutex
 	writeJSON := func(v interface{}) error {
@@ -38,43 +35,50 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) error
 	}
 
 	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
+		ICEServers: s.config.ICEServers,
 	}
 
-	peerConnection, err := webrtc.NewPeerConnection(config)
+	peerConnection, err := s.config.newPeerConnection(config)
 	if err != nil {
-		Log(Error, "network litmus peer connection failed", Entry{"error", err})
+		s.log(Error, "network litmus peer connection failed", Entry{"error", err})
 		return err
 	}
 	defer peerConnection.Close()
 
 	connID := randomConnID()
-	s.connections.Store(connID, peerConnection)
-	defer s.connections.Delete(connID)
 
 	testDone := make(chan struct{})
 	testError := make(chan error, 1)
 	
-	// Initialize NetworkTuner with default settings
-	networkTuner := NewNetworkTuner(
-		2000,    // Start at 1 Mbps
-		15000,   // Max 20 Mbps
-		1000,    // 1 Mbps steps
-	)
+	// The NetworkTuners are created once the offer brings the test parameters
+	sess := &session{
+		connID:         connID,
+		peerConnection: peerConnection,
+		writeJSON:      writeJSON,
+		closeSignaling: ws.Close,
+		remoteAddr:     s.clientAddr(r),
+		userAgent:      r.UserAgent(),
+		origin:         r.Header.Get("Origin"),
+		started:        time.Now(),
+		priority:       s.prioritize(r),
+		direction:      DirectionDownload,
+		version:        legacyVersion,
+	}
+	s.connections.Store(connID, sess)
+	defer s.connections.Delete(connID)
+	defer s.admission.release(connID)
+	defer s.finishTest(sess, TestResult{}, FailureAborted) // unless an outcome was recorded before
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Disconnected is left alone: ICE may recover from it, and goes on to Failed if it does not
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		if state == webrtc.PeerConnectionStateClosed ||
-		   state == webrtc.PeerConnectionStateFailed ||
-		   state == webrtc.PeerConnectionStateDisconnected {
+		   state == webrtc.PeerConnectionStateFailed {
 			s.connections.Delete(connID)
 			if state == webrtc.PeerConnectionStateFailed {
+				s.finishTest(sess, TestResult{}, FailureConnectionFailed)
 				select {
 				case testError <- ErrConnectionFailed:
 				default:
@@ -90,24 +94,48 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) error
 			return
 		}
 		
-		if err := writeJSON(map[string]interface{}{
-			"type": "candidate",
-			"candidate": i.ToJSON(),
+		if err := writeJSON(CandidateMessage{
+			Type:      MessageCandidate,
+			Candidate: i.ToJSON(),
 		}); err != nil {
-			Log(Error, "failed to send ICE candidate", 
+			s.log(Error, "failed to send ICE candidate", 
 				Entry{"error", err},
 				Entry{"connID", connID})
 		}
 	})
 	
 	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
-		go stream(ctx, dc, connID, testDone, testError, networkTuner, peerConnection)
+		go s.runTest(ctx, sess, dc, testDone, testError)
 	
 		dc.OnClose(func() {
 			cancel()
 		})
 	})
 
+	// Messages are read on their own goroutine, so that the loop also wakes up when a waiting offer is admitted
+	messages := make(chan []byte)
+	readError := make(chan error, 1)
+	stopReading := make(chan struct{})
+	defer close(stopReading)
+	go func() {
+		for {
+			data, err := readMessage(ws)
+			if err != nil {
+				readError <- err
+				return
+			}
+			select {
+			case messages <- data:
+			case <-stopReading:
+				return
+			}
+		}
+	}()
+
+	// While an offer waits for capacity, it and the messages that follow are held, then handled in order once admitted
+	var waiting *ticket
+	var held []interface{}
+
 	for {
 		select {
 		case err := <-testError:
@@ -115,111 +143,210 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) error
 		case <-testDone:
 			return nil
 		default:
-			var msg map[string]interface{}
-			if err := ws.ReadJSON(&msg); err != nil {
-				if websocket.IsUnexpectedCloseError(err, 
-					websocket.CloseGoingAway,
-					websocket.CloseNoStatusReceived) {
-					Log(Error, "unexpected websocket close", 
-						Entry{"error", err},
-						Entry{"connID", connID})
-					return err
-				}
-				return nil
+			var admitted <-chan struct{}
+			if waiting != nil {
+				admitted = waiting.done
 			}
 
-			msgType, ok := msg["type"].(string)
-			if !ok {
-				Log(Error, "invalid message type", 
-					Entry{"connID", connID})
-				continue
+			var decoded interface{}
+			if waiting == nil && len(held) > 0 {
+				decoded, held = held[0], held[1:]
+			} else {
+				select {
+				case err := <-testError:
+					return err
+				case <-testDone:
+					return nil
+
+				case <-admitted:
+					if !waiting.admitted {
+						// the waiting room was emptied by Shutdown
+						writeJSON(protocolErrorf(CodeShuttingDown, "server is shutting down").ErrorMessage())
+						return nil
+					}
+					s.log(Info, "litmus session admitted from the waiting room", Entry{"connID", connID})
+					waiting = nil
+					continue
+
+				case err := <-readError:
+					if errors.Is(err, websocket.ErrReadLimit) {
+						s.log(Warning, "litmus message exceeds the size limit, connection closed",
+							Entry{"limit", MaxMessageSize},
+							Entry{"connID", connID})
+						return nil
+					}
+					if websocket.IsUnexpectedCloseError(err,
+						websocket.CloseGoingAway,
+						websocket.CloseNoStatusReceived) {
+						s.log(Error, "unexpected websocket close",
+							Entry{"error", err},
+							Entry{"connID", connID})
+						return err
+					}
+					return nil
+
+				case data := <-messages:
+					// Malformed messages are reported back to the client and otherwise ignored
+					var err error
+					decoded, err = sess.decode(data)
+					if err != nil {
+						if err := s.replyError(writeJSON, connID, err); err != nil {
+							return err
+						}
+						continue
+					}
+					if waiting != nil {
+						held = append(held, decoded)
+						continue
+					}
+				}
 			}
 
-			switch msgType {
-			case "metrics_report":
-				lossRate, _ := msg["loss_rate"].(float64)
-				jitter, _ := msg["jitter"].(float64)
-				actualThroughput, _ := msg["actual_throughput"].(float64)
+			switch msg := decoded.(type) {
+			case *HelloMessage:
+				if err := s.handleHello(sess, msg); err != nil {
+					if err := s.replyError(writeJSON, connID, err); err != nil {
+						return err
+					}
+					var protocolErr *ProtocolError
+					if errors.As(err, &protocolErr) && protocolErr.Code == CodeUnsupportedVersion {
+						return nil
+					}
+				}
 
+			case *MetricsReportMessage:
+				networkTuner := sess.tuner(DirectionDownload)
+				if networkTuner == nil {
+					if err := s.replyError(writeJSON, connID, protocolErrorf(CodeInvalidMessage, "metrics_report before offer")); err != nil {
+						return err
+					}
+					continue
+				}
 
 				serverEffectiveRate := networkTuner.GetServerEffectiveRate()
-				shouldContinue := networkTuner.adjustBitrate(lossRate, jitter, actualThroughput, serverEffectiveRate)
-				
-				// Send current state back to client
-				if err := writeJSON(map[string]interface{}{
-					"type":    "bitrate_update",
-					"bitrate": networkTuner.getCurrentBitrate(),
-					"final":   !shouldContinue,
+				s.adjustBitrate(sess, DirectionDownload, networkTuner, msg.LossRate, msg.Jitter, msg.ActualThroughput, serverEffectiveRate)
+
+				// Send current state back to client, skipped reports included
+				// final only ends the download phase, test_complete is sent once all phases are done
+				if err := writeJSON(BitrateUpdateMessage{
+					Type:      MessageBitrateUpdate,
+					Direction: DirectionDownload,
+					Bitrate:   networkTuner.getCurrentBitrate(),
+					Final:     networkTuner.IsTestComplete(),
 				}); err != nil {
-					Log(Error, "Failed to send bitrate update",
+					s.log(Error, "Failed to send bitrate update",
 						Entry{"error", err},
 						Entry{"connID", connID})
 					return err
 				}
 
-				// If test is complete, send final results
-				if !shouldContinue {
-					capability := networkTuner.GetCapability()
-					if err := writeJSON(map[string]interface{}{
-						"type":    "test_complete",
-						"bitrate": capability.MaxStableBitrate,
-						"final":   true,
-					}); err != nil {
-						Log(Error, "Failed to send test complete message",
-							Entry{"error", err},
-							Entry{"connID", connID})
+			case *OfferMessage:
+				// the running test holds on to the tuners and the peer connection of the first offer
+				if sess.hasOffered() {
+					if err := s.replyError(writeJSON, connID, protocolErrorf(CodeInvalidMessage, "offer must be sent once")); err != nil {
 						return err
 					}
+					continue
 				}
+				if msg.Direction.includesUpload() && !sess.hasFeature(FeatureUpload) {
+					if err := s.replyError(writeJSON, connID, protocolErrorf(CodeUnsupportedFeature, "%s direction requires the %s feature", msg.Direction, FeatureUpload)); err != nil {
+						return err
+					}
+					continue
+				}
+
+				if msg.Parameters != nil && !sess.hasFeature(FeatureParameters) {
+					if err := s.replyError(writeJSON, connID, protocolErrorf(CodeUnsupportedFeature, "offer parameters require the %s feature", FeatureParameters)); err != nil {
+						return err
+					}
+					continue
+				}
+				var params TestParameters
+				if msg.Parameters != nil {
+					params = *msg.Parameters
+				}
+				config, err := s.config.ApplyParameters(params, msg.Direction)
+				if err != nil {
+					if err := s.replyError(writeJSON, connID, protocolErrorf(CodeInvalidMessage, "offer: %v", err)); err != nil {
+						return err
+					}
+					continue
+				}
+				ticket, retryAfter, ok := s.admit(sess, config, msg.Direction)
+				if !ok {
+					s.log(Info, "litmus session rejected, egress budget exhausted",
+						Entry{"retryAfter", retryAfter},
+						Entry{"connID", connID})
+					rejection := protocolErrorf(CodeAtCapacity, "server at capacity")
+					rejection.RetryAfter = retryAfter
+					writeJSON(rejection.ErrorMessage())
+					return nil
+				}
+				if ticket != nil {
+					s.log(Info, "litmus session waiting for capacity",
+						Entry{"priority", sess.priority},
+						Entry{"connID", connID})
+					waiting = ticket
+					held = append([]interface{}{msg}, held...)
+					continue
+				}
+				sess.configure(config, msg.Direction)
+				s.metrics.sessionStarted()
+				s.emit(sess, Event{
+					Type:      EventSessionStarted,
+					Direction: msg.Direction,
+				})
 
-			case "offer":
 				if err := peerConnection.SetRemoteDescription(
 					webrtc.SessionDescription{
 						Type: webrtc.SDPTypeOffer,
-						SDP:  msg["sdp"].(string),
+						SDP:  msg.SDP,
 					},
 				); err != nil {
-					Log(Error, "network test set remote description failed", 
+					s.log(Error, "network test set remote description failed", 
 						Entry{"error", err},
 						Entry{"connID", connID})
+					s.finishTest(sess, TestResult{}, FailureNegotiation)
+					s.replyError(writeJSON, connID, protocolErrorf(CodeNegotiationFailed, "invalid offer: %v", err))
 					return err
 				}
 
 				answer, err := peerConnection.CreateAnswer(nil)
 				if err != nil {
-					Log(Error, "network test create answer failed", 
+					s.log(Error, "network test create answer failed", 
 						Entry{"error", err},
 						Entry{"connID", connID})
+					s.finishTest(sess, TestResult{}, FailureNegotiation)
+					s.replyError(writeJSON, connID, protocolErrorf(CodeNegotiationFailed, "create answer failed"))
 					return err
 				}
 
 				if err = peerConnection.SetLocalDescription(answer); err != nil {
-					Log(Error, "network test set local description failed", 
+					s.log(Error, "network test set local description failed", 
 						Entry{"error", err},
 						Entry{"connID", connID})
+					s.finishTest(sess, TestResult{}, FailureNegotiation)
+					s.replyError(writeJSON, connID, protocolErrorf(CodeNegotiationFailed, "set local description failed"))
 					return err
 				}
 
-				if err := writeJSON(map[string]interface{}{
-					"type": "answer",
-					"sdp":  answer.SDP,
+				if err := writeJSON(AnswerMessage{
+					Type: MessageAnswer,
+					SDP:  answer.SDP,
 				}); err != nil {
 					return err
 				}
 
-			case "candidate":
-				candidate, ok := msg["candidate"].(map[string]interface{})
-				if !ok {
-					Log(Error, "invalid candidate format",
-						Entry{"connID", connID})
+			case *CandidateMessage:
+				if msg.Candidate.Candidate == "" {
 					continue
 				}
-				if err := peerConnection.AddICECandidate(webrtc.ICECandidateInit{
-					Candidate: candidate["candidate"].(string),
-				}); err != nil {
-					Log(Error, "network test add ice candidate failed", 
+				if err := peerConnection.AddICECandidate(msg.Candidate); err != nil {
+					s.log(Error, "network test add ice candidate failed", 
 						Entry{"error", err},
 						Entry{"connID", connID})
+					s.finishTest(sess, TestResult{}, FailureNegotiation)
+					s.replyError(writeJSON, connID, protocolErrorf(CodeNegotiationFailed, "invalid candidate: %v", err))
 					return err
 				}
 			}
@@ -227,6 +354,75 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) error
 	}
 }
 
+// handleHello negotiates the protocol version and features and answers the client.
+// Clients older than MinProtocolVersion are refused, newer ones are downgraded to ProtocolVersion.
+func (s *Server) handleHello(sess *session, msg *HelloMessage) error {
+	sess.mu.Lock()
+	done := sess.negotiated || sess.offered
+	sess.mu.Unlock()
+	if done {
+		return protocolErrorf(CodeInvalidMessage, "hello must be sent once, before the offer")
+	}
+
+	if msg.Version < MinProtocolVersion {
+		return protocolErrorf(CodeUnsupportedVersion, "protocol version %d is not supported, minimum is %d", msg.Version, MinProtocolVersion)
+	}
+
+	version := msg.Version
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+	features := negotiateFeatures(s.features(), msg.Features)
+	sess.setProtocol(version, features)
+
+	s.log(Info, "protocol negotiated",
+		Entry{"version", version},
+		Entry{"features", features},
+		Entry{"connID", sess.connID})
+
+	return sess.writeJSON(HelloMessage{
+		Type:     MessageHello,
+		Version:  version,
+		Features: features,
+	})
+}
+
+// readMessage reads a single text message, skipping binary ones.
+// Messages over the read limit end the connection with websocket.ErrReadLimit.
+func readMessage(ws *websocket.Conn) ([]byte, error) {
+	for {
+		messageType, r, err := ws.NextReader()
+		if err != nil {
+			return nil, err
+		}
+		if messageType != websocket.TextMessage {
+			// the unread frame is discarded by the next NextReader call
+			continue
+		}
+		return io.ReadAll(r)
+	}
+}
+
+// replyError logs a protocol error and reports it to the client
+func (s *Server) replyError(writeJSON func(v interface{}) error, connID string, err error) error {
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		protocolErr = &ProtocolError{Code: CodeInternal, Message: "internal error"}
+	}
+
+	s.log(Warning, "invalid client message",
+		Entry{"error", err},
+		Entry{"connID", connID})
+
+	if err := writeJSON(protocolErr.ErrorMessage()); err != nil {
+		s.log(Error, "Failed to send error message",
+			Entry{"error", err},
+			Entry{"connID", connID})
+		return err
+	}
+	return nil
+}
+
 func randomConnID() string {
 	return strconv.FormatInt(time.Now().UnixNano(), 36)
 }
\ No newline at end of file
@@ -0,0 +1,340 @@
+package litmus
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// defaultImpairmentQueue is how much traffic the emulated bottleneck buffers when Impairment.Queue is not set
+const defaultImpairmentQueue = 100 * time.Millisecond
+
+// Impairment describes an emulated network link, applied in process to the test traffic of every session.
+// The same impairment applies in each direction.
+type Impairment struct {
+	Rate   int           // kbps, 0 for unlimited
+	Delay  time.Duration // one way
+	Jitter time.Duration // maximum extra one way delay, drawn per packet
+	Loss   float64       // fraction of packets dropped at random
+	Queue  time.Duration // traffic the bottleneck buffers before dropping, at Rate; 0 uses 100ms
+}
+
+// ParseImpairment parses a comma separated impairment spec such as "5mbit,40ms,1%loss".
+//
+// Rates use tc units (bit, kbit, mbit, gbit). A bare duration is the delay, a duration suffixed with
+// "jitter" or "queue" sets those instead, as in "10msjitter" or "200msqueue". Loss is a percentage suffixed with "loss".
+func ParseImpairment(spec string) (Impairment, error) {
+	var imp Impairment
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(token, "%loss"):
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(token, "%loss"), 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return Impairment{}, fmt.Errorf("impairment %q: invalid loss", token)
+			}
+			imp.Loss = percent / 100
+		case strings.HasSuffix(token, "bit"):
+			rate, err := parseRate(token)
+			if err != nil {
+				return Impairment{}, fmt.Errorf("impairment %q: %w", token, err)
+			}
+			imp.Rate = rate
+		case strings.HasSuffix(token, "jitter"):
+			d, err := parseImpairmentDuration(token, "jitter")
+			if err != nil {
+				return Impairment{}, err
+			}
+			imp.Jitter = d
+		case strings.HasSuffix(token, "queue"):
+			d, err := parseImpairmentDuration(token, "queue")
+			if err != nil {
+				return Impairment{}, err
+			}
+			imp.Queue = d
+		default:
+			d, err := parseImpairmentDuration(token, "delay")
+			if err != nil {
+				return Impairment{}, err
+			}
+			imp.Delay = d
+		}
+	}
+	return imp, nil
+}
+
+// parseRate parses a tc style rate into kbps
+func parseRate(token string) (int, error) {
+	units := []struct {
+		suffix string
+		kbps   float64
+	}{
+		{"gbit", 1000000},
+		{"mbit", 1000},
+		{"kbit", 1},
+		{"bit", 0.001},
+	}
+	for _, unit := range units {
+		if !strings.HasSuffix(token, unit.suffix) {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSuffix(token, unit.suffix), 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid rate")
+		}
+		return int(value * unit.kbps), nil
+	}
+	return 0, fmt.Errorf("unknown rate unit")
+}
+
+func parseImpairmentDuration(token, suffix string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSuffix(token, suffix))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("impairment %q: invalid %s", token, suffix)
+	}
+	return d, nil
+}
+
+// String formats the impairment as a spec accepted by ParseImpairment
+func (imp Impairment) String() string {
+	var tokens []string
+	if imp.Rate > 0 {
+		tokens = append(tokens, strconv.Itoa(imp.Rate)+"kbit")
+	}
+	if imp.Delay > 0 {
+		tokens = append(tokens, imp.Delay.String())
+	}
+	if imp.Jitter > 0 {
+		tokens = append(tokens, imp.Jitter.String()+"jitter")
+	}
+	if imp.Loss > 0 {
+		tokens = append(tokens, strconv.FormatFloat(imp.Loss*100, 'f', -1, 64)+"%loss")
+	}
+	if imp.Queue > 0 {
+		tokens = append(tokens, imp.Queue.String()+"queue")
+	}
+	return strings.Join(tokens, ",")
+}
+
+// dataSender is the sending side of a data channel
+type dataSender interface {
+	Send(data []byte) error
+	BufferedAmount() uint64
+}
+
+// emulator impairs the test traffic of a session. A nil emulator leaves traffic untouched.
+type emulator struct {
+	downlink *impairedLink // server to client
+	uplink   *impairedLink // client to server
+}
+
+func newEmulator(imp *Impairment) *emulator {
+	if imp == nil {
+		return nil
+	}
+	return &emulator{
+		downlink: newImpairedLink(*imp),
+		uplink:   newImpairedLink(*imp),
+	}
+}
+
+// sender returns dc with its outgoing messages passed through the downlink
+func (e *emulator) sender(dc *webrtc.DataChannel) dataSender {
+	if e == nil {
+		return dc
+	}
+	return &impairedSender{link: e.downlink, dc: dc}
+}
+
+// receiver returns handler with incoming messages passed through the uplink before it sees them
+func (e *emulator) receiver(handler func(webrtc.DataChannelMessage)) func(webrtc.DataChannelMessage) {
+	if e == nil {
+		return handler
+	}
+	return func(msg webrtc.DataChannelMessage) {
+		e.uplink.enqueue(len(msg.Data), func() {
+			handler(msg)
+		})
+	}
+}
+
+func (e *emulator) close() {
+	if e == nil {
+		return
+	}
+	e.downlink.close()
+	e.uplink.close()
+}
+
+type impairedSender struct {
+	link *impairedLink
+	dc   *webrtc.DataChannel
+
+	mu  sync.Mutex
+	err error // first delayed send failure, returned by the next Send
+}
+
+func (s *impairedSender) Send(data []byte) error {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.link.enqueue(len(data), func() {
+		if err := s.dc.Send(data); err != nil {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+		}
+	})
+	return nil
+}
+
+// BufferedAmount counts the bytes held by the emulated link as buffered too
+func (s *impairedSender) BufferedAmount() uint64 {
+	return s.dc.BufferedAmount() + s.link.queuedBytes()
+}
+
+type impairedPacket struct {
+	at      time.Time
+	size    int
+	deliver func()
+}
+
+// impairedLink delivers packets in order, after a token bucket bottleneck with a tail drop queue,
+// a delay line and random loss
+type impairedLink struct {
+	impairment Impairment
+
+	mu     sync.Mutex
+	random *rand.Rand
+	queue  []impairedPacket
+	queued uint64    // bytes waiting for delivery
+	free   time.Time // when the bottleneck is done with the packets queued so far
+	last   time.Time // delivery time of the last packet queued, later packets are never delivered before it
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newImpairedLink(imp Impairment) *impairedLink {
+	if imp.Queue <= 0 {
+		imp.Queue = defaultImpairmentQueue
+	}
+	l := &impairedLink{
+		impairment: imp,
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// enqueue schedules deliver for a packet of size bytes, unless the packet is lost or the queue is full
+func (l *impairedLink) enqueue(size int, deliver func()) {
+	now := time.Now()
+	imp := l.impairment
+
+	l.mu.Lock()
+	if imp.Loss > 0 && l.random.Float64() < imp.Loss {
+		l.mu.Unlock()
+		return
+	}
+
+	departure := now
+	if imp.Rate > 0 {
+		if l.free.Before(now) {
+			l.free = now
+		}
+		if l.free.Sub(now) > imp.Queue {
+			l.mu.Unlock()
+			return
+		}
+		l.free = l.free.Add(time.Duration(size) * 8 * time.Millisecond / time.Duration(imp.Rate))
+		departure = l.free
+	}
+
+	at := departure.Add(imp.Delay)
+	if imp.Jitter > 0 {
+		at = at.Add(time.Duration(l.random.Int63n(int64(imp.Jitter))))
+	}
+	if at.Before(l.last) {
+		at = l.last
+	}
+	l.last = at
+
+	l.queue = append(l.queue, impairedPacket{at: at, size: size, deliver: deliver})
+	l.queued += uint64(size)
+	l.mu.Unlock()
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *impairedLink) queuedBytes() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// run delivers queued packets when they are due, until the link is closed
+func (l *impairedLink) run() {
+	for {
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+
+		l.mu.Lock()
+		var due *impairedPacket
+		var wait <-chan time.Time
+		var timer *time.Timer
+		if len(l.queue) > 0 {
+			if next := l.queue[0]; !next.at.After(time.Now()) {
+				due = &next
+				l.queue = l.queue[1:]
+				l.queued -= uint64(next.size)
+			} else {
+				timer = time.NewTimer(time.Until(next.at))
+				wait = timer.C
+			}
+		}
+		l.mu.Unlock()
+
+		if due != nil {
+			due.deliver()
+			continue
+		}
+
+		select {
+		case <-l.done:
+		case <-l.wake:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// close stops delivery, packets still queued are dropped
+func (l *impairedLink) close() {
+	close(l.done)
+}
@@ -0,0 +1,66 @@
+package litmus
+
+import (
+	"time"
+)
+
+// EventType identifies what happened to a session
+type EventType string
+
+const (
+	EventSessionStarted EventType = "session_started" // the client offered a test, it is about to run
+	EventBitrateChanged EventType = "bitrate_changed" // a tuner moved to another bitrate
+	EventTestCompleted  EventType = "test_completed"  // the result was recorded, it is about to be reported to the client
+	EventTestFailed     EventType = "test_failed"     // the test ended without a result
+)
+
+// Event describes something that happened to a session, for applications embedding the Server.
+// Fields not relevant to the event type are zero.
+type Event struct {
+	Type   EventType
+	ConnID string
+	Time   time.Time
+	Client ClientInfo // request metadata of the session
+
+	// Direction is the requested direction for session_started, the direction whose tuner moved for bitrate_changed
+	Direction Direction
+
+	// bitrate_changed
+	Bitrate         int // kbps, after the change
+	PreviousBitrate int // kbps
+	Decision        Decision
+
+	// test_completed, and test_failed for the directions that completed before the failure
+	Result *TestResult
+	// test_completed
+	Capability *NetworkCapability // usable for a two way call, the worse of the tested directions
+	Report     *TestReport        // as sent to the client
+
+	// test_failed, one of the Failure constants
+	Reason string
+}
+
+// OnEvent registers handler to be called with every session event.
+// Handlers run synchronously on the goroutine running the session, in registration order, and must return quickly.
+func (s *Server) OnEvent(handler func(Event)) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	s.eventHandlers = append(s.eventHandlers, handler)
+}
+
+// emit fills in the session fields of e and hands it to the registered handlers
+func (s *Server) emit(sess *session, e Event) {
+	s.eventsMu.RLock()
+	handlers := s.eventHandlers
+	s.eventsMu.RUnlock()
+	if len(handlers) == 0 {
+		return
+	}
+
+	e.ConnID = sess.connID
+	e.Time = time.Now()
+	e.Client = sess.clientInfo()
+	for _, handler := range handlers {
+		handler(e)
+	}
+}
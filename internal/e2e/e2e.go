@@ -0,0 +1,120 @@
+// Package e2e runs litmus tests end to end inside a single process.
+//
+// A Server and a headless client are connected through a pion virtual network that emulates a Link with
+// limited bandwidth, delay, jitter and loss. Signaling goes over a loopback websocket, media never touches a real
+// network interface, so scenarios run on any machine without network access.
+//
+// The package only serves its own tests, run them with go test ./internal/e2e.
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kickback-space/litmus"
+	"github.com/kickback-space/litmus/client"
+)
+
+// Run serves a litmus Server configured with opts, then runs a single test in direction over link
+func Run(ctx context.Context, link Link, direction litmus.Direction, opts ...litmus.Option) (*client.Result, error) {
+	network, err := newNetwork(link)
+	if err != nil {
+		return nil, fmt.Errorf("virtual network: %w", err)
+	}
+	defer network.close()
+
+	opts = append(opts,
+		litmus.WithAPI(network.serverAPI),
+		litmus.WithICEServers(),
+	)
+	server := litmus.NewServer(0, opts...)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	server.RegisterHandlers(mux, "")
+	httpServer := &http.Server{Handler: mux}
+	go httpServer.Serve(listener)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		httpServer.Close()
+	}()
+
+	c := client.New(
+		client.WithAPI(network.clientAPI),
+		client.WithICEServers(),
+		client.WithDirection(direction),
+	)
+	return c.Run(ctx, "ws://"+listener.Addr().String()+"/litmus")
+}
+
+// Expectation is the range the measured max stable bitrate of a direction must fall in, in kbps
+type Expectation struct {
+	Min int
+	Max int
+}
+
+// Scenario is a test over an emulated link and the outcome it should have
+type Scenario struct {
+	Name      string
+	Link      Link
+	Direction litmus.Direction
+	Options   []litmus.Option // server options, on top of the suite defaults
+	Download  *Expectation    // nil if not checked
+	Upload    *Expectation    // nil if not checked
+}
+
+// Outcome is the result of running a Scenario
+type Outcome struct {
+	Result   *client.Result
+	Failures []string // expectations that were not met, empty if the scenario passed
+}
+
+// ErrNotTested is reported when an expectation targets a direction the result has no capability for
+var ErrNotTested = errors.New("direction not tested")
+
+// Run runs the scenario. The error is only set if the test could not run at all, unmet expectations are failures.
+func (s Scenario) Run(ctx context.Context) (Outcome, error) {
+	opts := append(SuiteOptions(), s.Options...)
+
+	result, err := Run(ctx, s.Link, s.Direction, opts...)
+	outcome := Outcome{Result: result}
+	if err != nil {
+		return outcome, err
+	}
+
+	check := func(direction litmus.Direction, capability *litmus.NetworkCapability, expectation *Expectation) {
+		if expectation == nil {
+			return
+		}
+		if capability == nil {
+			outcome.Failures = append(outcome.Failures, fmt.Sprintf("%s: %v", direction, ErrNotTested))
+			return
+		}
+		if bitrate := capability.MaxStableBitrate; bitrate < expectation.Min || bitrate > expectation.Max {
+			outcome.Failures = append(outcome.Failures, fmt.Sprintf("%s: max stable bitrate %d kbps outside [%d, %d]",
+				direction, bitrate, expectation.Min, expectation.Max))
+		}
+	}
+	check(litmus.DirectionDownload, result.Download, s.Download)
+	check(litmus.DirectionUpload, result.Upload, s.Upload)
+	return outcome, nil
+}
+
+// SuiteOptions returns the server options scenarios run with, sized so that a linear search
+// reaches the suite's link rates within the test duration
+func SuiteOptions() []litmus.Option {
+	return []litmus.Option{
+		litmus.WithTunerBounds(suiteInitialBitrate, suiteMaxBitrate, suiteStepSize),
+		litmus.WithMinBitrate(suiteMinBitrate),
+		litmus.WithMaxTestDuration(20 * time.Second),
+	}
+}
@@ -0,0 +1,38 @@
+package e2e
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/blitz-frost/log"
+	"github.com/kickback-space/litmus"
+)
+
+// scenarioTimeout bounds a single scenario, twice the suite test duration per direction
+const scenarioTimeout = time.Minute
+
+// TestSuite runs every built-in scenario over the virtual network, skipped with -short since each takes
+// as long as the test it runs
+func TestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end to end scenarios take several seconds each")
+	}
+
+	for _, scenario := range Suite() {
+		t.Run(scenario.Name, func(t *testing.T) {
+			scenario.Options = append(scenario.Options, litmus.WithLogger(LineLoggerMake(io.Discard, nil)))
+
+			ctx, cancel := context.WithTimeout(context.Background(), scenarioTimeout)
+			defer cancel()
+			outcome, err := scenario.Run(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, failure := range outcome.Failures {
+				t.Error(failure)
+			}
+		})
+	}
+}
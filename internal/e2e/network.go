@@ -0,0 +1,122 @@
+package e2e
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/logging"
+	"github.com/pion/transport/v2/vnet"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	serverIP = "10.0.0.1"
+	clientIP = "10.0.0.2"
+)
+
+// Link describes the emulated network between the server and the client
+type Link struct {
+	Downlink int           // kbps, server to client, 0 for unlimited
+	Uplink   int           // kbps, client to server, 0 for unlimited
+	Delay    time.Duration // one way
+	Jitter   time.Duration // maximum extra one way delay, drawn per packet
+	Loss     float64       // fraction of packets dropped, in each direction
+}
+
+// network is a virtual network joining a server and a client through an emulated link
+type network struct {
+	router  *vnet.Router
+	filters []*vnet.TokenBucketFilter
+
+	serverAPI *webrtc.API
+	clientAPI *webrtc.API
+}
+
+func newNetwork(link Link) (*network, error) {
+	router, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "10.0.0.0/24",
+		MinDelay:      link.Delay,
+		MaxJitter:     link.Jitter,
+		QueueSize:     10000,
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n := &network{router: router}
+
+	if link.Loss > 0 {
+		var mu sync.Mutex
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		router.AddChunkFilter(func(c vnet.Chunk) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return random.Float64() >= link.Loss
+		})
+	}
+
+	if n.serverAPI, err = n.addHost(serverIP, link.Uplink); err != nil {
+		n.close()
+		return nil, err
+	}
+	if n.clientAPI, err = n.addHost(clientIP, link.Downlink); err != nil {
+		n.close()
+		return nil, err
+	}
+
+	if err := router.Start(); err != nil {
+		n.close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// addHost attaches a host to the router, with its inbound traffic limited to rate kbps if not 0.
+// Returns the pion API to create the host's peer connections with.
+func (n *network) addHost(ip string, rate int) (*webrtc.API, error) {
+	host, err := vnet.NewNet(&vnet.NetConfig{
+		StaticIPs: []string{ip},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var nic vnet.NIC = host
+	if rate > 0 {
+		// The filter refills its bucket every 100ms at most, a burst of 200ms of traffic keeps traffic below rate unshaped.
+		// The queue holds as much again, deeper queues only add delay.
+		window := rate * vnet.KBit / 8 / 5 // bytes
+		if window < 16*1024 {
+			window = 16 * 1024
+		}
+		filter, err := vnet.NewTokenBucketFilter(host,
+			vnet.TBFRate(rate*vnet.KBit),
+			vnet.TBFMaxBurst(window),
+			vnet.TBFQueueSizeInBytes(window),
+		)
+		if err != nil {
+			return nil, err
+		}
+		n.filters = append(n.filters, filter)
+		nic = filter
+	}
+
+	if err := n.router.AddNet(nic); err != nil {
+		return nil, err
+	}
+
+	settings := webrtc.SettingEngine{}
+	settings.SetVNet(host)
+	settings.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	return webrtc.NewAPI(webrtc.WithSettingEngine(settings)), nil
+}
+
+func (n *network) close() {
+	n.router.Stop()
+	for _, filter := range n.filters {
+		filter.Close()
+	}
+}
@@ -0,0 +1,73 @@
+package e2e
+
+import (
+	"time"
+
+	"github.com/kickback-space/litmus"
+)
+
+// Tuner bounds of the suite, in kbps
+const (
+	suiteInitialBitrate = 1000
+	suiteMaxBitrate     = 8000
+	suiteStepSize       = 1000
+	suiteMinBitrate     = 500
+)
+
+// around expects the rate of a link, give or take one step of the linear search
+func around(rate int) *Expectation {
+	return &Expectation{Min: rate - suiteStepSize, Max: rate + suiteStepSize}
+}
+
+// unusable expects no stable bitrate at all, for links whose loss or jitter fails the thresholds at any rate
+var unusable = &Expectation{Min: 0, Max: 0}
+
+// Suite returns the built-in scenarios.
+// Capped links are expected at their rate, give or take one step, the unconstrained one at the suite maximum.
+func Suite() []Scenario {
+	return []Scenario{
+		{
+			Name:      "clean",
+			Link:      Link{Delay: 10 * time.Millisecond},
+			Direction: litmus.DirectionDownload,
+			Download:  around(suiteMaxBitrate),
+		},
+		{
+			Name:      "capped-5mbit",
+			Link:      Link{Downlink: 5000, Delay: 20 * time.Millisecond},
+			Direction: litmus.DirectionDownload,
+			Download:  around(5000),
+		},
+		{
+			Name:      "capped-3mbit",
+			Link:      Link{Downlink: 3000, Delay: 20 * time.Millisecond},
+			Direction: litmus.DirectionDownload,
+			Download:  around(3000),
+		},
+		{
+			Name:      "lossy",
+			Link:      Link{Delay: 10 * time.Millisecond, Loss: 0.05},
+			Direction: litmus.DirectionDownload,
+			Download:  unusable,
+		},
+		{
+			Name:      "jittery",
+			Link:      Link{Delay: 10 * time.Millisecond, Jitter: 200 * time.Millisecond},
+			Direction: litmus.DirectionDownload,
+			Download:  unusable,
+		},
+		{
+			Name:      "capped-4mbit-upload",
+			Link:      Link{Uplink: 4000, Delay: 20 * time.Millisecond},
+			Direction: litmus.DirectionUpload,
+			Upload:    around(4000),
+		},
+		{
+			Name:      "asymmetric",
+			Link:      Link{Downlink: 6000, Uplink: 3000, Delay: 15 * time.Millisecond},
+			Direction: litmus.DirectionBoth,
+			Download:  around(6000),
+			Upload:    around(3000),
+		},
+	}
+}
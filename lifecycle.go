@@ -0,0 +1,135 @@
+package litmus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	. "github.com/blitz-frost/log"
+)
+
+var ErrShuttingDown = errors.New("litmus server shutting down")
+
+// newHTTPServer creates the standalone HTTP server, which Shutdown will stop
+func (s *Server) newHTTPServer(pathBase string) *http.Server {
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux, pathBase)
+
+	srv := &http.Server{
+		Addr:    ":" + strconv.FormatUint(uint64(s.port), 10),
+		Handler: mux,
+	}
+
+	s.lifecycleMu.Lock()
+	s.httpServer = srv
+	s.lifecycleMu.Unlock()
+	return srv
+}
+
+// Start listens on the server port and serves in the background.
+// Returns once the listener is bound, use Shutdown to stop.
+func (s *Server) Start(pathBase string) error {
+	return s.start(pathBase, "", "")
+}
+
+// StartTLS is the HTTPS counterpart of Start
+func (s *Server) StartTLS(pathBase, certFile, keyFile string) error {
+	return s.start(pathBase, certFile, keyFile)
+}
+
+func (s *Server) start(pathBase, certFile, keyFile string) error {
+	srv := s.newHTTPServer(pathBase)
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var err error
+		if certFile != "" {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.err(Critical, "litmus server stopped", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting new sessions and waits for running tests to finish.
+// If ctx is done first, remaining clients are sent a shutting_down error and their connections are closed.
+//
+// Also usable when the handlers were registered on an external ServeMux, in which case only sessions are drained.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lifecycleMu.Lock()
+	s.draining = true
+	srv := s.httpServer
+	var drained chan struct{}
+	if s.active > 0 {
+		if s.drained == nil {
+			s.drained = make(chan struct{})
+		}
+		drained = s.drained
+	}
+	s.lifecycleMu.Unlock()
+
+	s.log(Notice, "litmus server shutting down")
+	s.admission.dropWaiting()
+
+	// Hijacked websocket connections are not tracked by http.Server, stopping it only closes the listener and idle connections
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+
+	if drained == nil {
+		return err
+	}
+
+	select {
+	case <-drained:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.connections.Range(func(key, value interface{}) bool {
+		s.finishTest(value.(*session), TestResult{}, FailureShuttingDown)
+		value.(*session).terminate(protocolErrorf(CodeShuttingDown, "server is shutting down"))
+		return true
+	})
+	return ctx.Err()
+}
+
+// beginSession registers a new session, unless the server is shutting down
+func (s *Server) beginSession() bool {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+
+	if s.draining {
+		return false
+	}
+	s.active++
+	return true
+}
+
+func (s *Server) endSession() {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+
+	s.active--
+	if s.active == 0 && s.drained != nil {
+		close(s.drained)
+		s.drained = nil
+	}
+}
+
+// isDraining reports whether Shutdown was called
+func (s *Server) isDraining() bool {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+	return s.draining
+}
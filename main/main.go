@@ -1,38 +1,68 @@
 package main
 
 import (
-	"github.com/kickback-space/litmus"
-	. "github.com/blitz-frost/log"
+	"fmt"
 	"os"
-	"os/signal"
-)
+	"strings"
 
-func main() {
+	. "github.com/blitz-frost/log"
+	"github.com/pion/webrtc/v3"
+)
 
-	defer Close()
+const usage = `usage: litmus <command> [flags]
 
-	go sigint()
+commands:
+  serve        run a litmus server (default)
+  test <url>   run a headless test against a litmus server
+  replay <trace.json>...
+               replay recorded timelines through the tuners
+  sign <url>   sign a litmus URL for servers authenticating with -auth-key
 
-	path := ""
-	go func() {
-		Log(Info, "Litmus server online.")
-		server := litmus.NewServer(8000)
+Run "litmus <command> -h" for the flags of a command.
+`
 
-		err := server.ListenStandalone(path)
-		if err != nil {
-			Err(Critical, "network litmus server listen", err)
-		}
-	}()
+func main() {
 
-	select {}
-}
+	defer Close()
 
-func sigint() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	<-c
+	var code int
+	switch command {
+	case "serve":
+		code = serve(args)
+	case "test":
+		code = test(args)
+	case "replay":
+		code = replay(args)
+	case "sign":
+		code = sign(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		code = 2
+	}
 
 	Close()
-	os.Exit(1)
+	os.Exit(code)
+}
+
+// iceServers parses a comma separated list of STUN/TURN URLs
+func iceServers(list string) []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	for _, url := range strings.Split(list, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		servers = append(servers, webrtc.ICEServer{
+			URLs: []string{url},
+		})
+	}
+	return servers
 }
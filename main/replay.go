@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kickback-space/litmus"
+)
+
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	strategies := flags.String("strategy", "", "comma separated strategies to replay with, those of the trace expectations or linear if empty")
+	check := flags.Bool("check", false, "compare outcomes to the trace expectations, exit status 1 if any changed")
+	update := flags.Bool("update", false, "write the outcomes to the trace files as their new expectations")
+	verbose := flags.Bool("v", false, "print every difference and the replayed timeline of changed outcomes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: litmus replay [flags] <trace.json>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TRACE\tSTRATEGY\tDOWNLOAD\tUPLOAD\tDIVERGED\tSTATUS")
+
+	changed := 0
+	for _, path := range flags.Args() {
+		trace, err := litmus.LoadTrace(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		names := strategyNames(*strategies, trace)
+		for _, name := range names {
+			strategy, ok := litmus.ParseStrategy(name)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "unknown strategy %q\n", name)
+				return 2
+			}
+
+			config := litmus.DefaultConfig()
+			config.Strategy = strategy
+			result := litmus.Replay(trace.Timeline, config)
+
+			status := ""
+			var diffs []string
+			if *check {
+				expected, ok := trace.Expect[name]
+				if !ok {
+					status = "NO EXPECTATION"
+				} else if diffs = result.Outcome.Diff(expected); len(diffs) > 0 {
+					status = "CHANGED: " + diffs[0]
+				} else {
+					status = "ok"
+				}
+				if status != "ok" {
+					changed++
+				}
+			}
+
+			diverged := "-"
+			if result.Diverged >= 0 {
+				diverged = fmt.Sprint(result.Diverged)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", trace.Name, name,
+				phaseSummary(result.Outcome, litmus.DirectionDownload),
+				phaseSummary(result.Outcome, litmus.DirectionUpload),
+				diverged, status)
+
+			if *verbose && len(diffs) > 0 {
+				w.Flush()
+				for _, diff := range diffs[1:] {
+					fmt.Println("    " + diff)
+				}
+				result.Timeline.WriteCSV(os.Stdout)
+			}
+
+			if *update {
+				if trace.Expect == nil {
+					trace.Expect = map[string]litmus.ReplayOutcome{}
+				}
+				trace.Expect[name] = result.Outcome
+			}
+		}
+
+		if *update {
+			if err := trace.Save(path); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+	}
+	w.Flush()
+
+	if changed > 0 {
+		fmt.Fprintf(os.Stderr, "%d outcome(s) changed\n", changed)
+		return 1
+	}
+	return 0
+}
+
+// strategyNames returns the strategies listed in flag, or those the trace has expectations for
+func strategyNames(flag string, trace *litmus.Trace) []string {
+	if flag != "" {
+		return strings.Split(flag, ",")
+	}
+
+	names := make([]string, 0, len(trace.Expect))
+	for name := range trace.Expect {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return []string{"linear"}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func phaseSummary(outcome litmus.ReplayOutcome, direction litmus.Direction) string {
+	phase, ok := outcome[direction]
+	if !ok {
+		return "-"
+	}
+	if phase.Complete {
+		return fmt.Sprintf("%d kbps", phase.MaxStableBitrate)
+	}
+	return fmt.Sprintf("%d kbps (cut)", phase.MaxStableBitrate)
+}
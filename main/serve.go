@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/blitz-frost/log"
+	"github.com/kickback-space/litmus"
+)
+
+func serve(args []string) int {
+	defaults := litmus.DefaultConfig()
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.Uint("port", 8000, "listen port")
+	path := flags.String("path", "", "path base prepended to /litmus")
+	cert := flags.String("cert", "", "TLS certificate file, enables HTTPS together with -key")
+	key := flags.String("key", "", "TLS key file")
+	ice := flags.String("ice", "stun:stun.l.google.com:19302", "comma separated ICE server URLs")
+	origins := flags.String("origins", "", "comma separated allowed websocket origins, all if empty")
+	initial := flags.Int("initial", defaults.InitialBitrate, "initial test bitrate in kbps")
+	max := flags.Int("max", defaults.MaxBitrate, "maximum test bitrate in kbps")
+	step := flags.Int("step", defaults.StepSize, "bitrate step in kbps")
+	min := flags.Int("min", defaults.MinBitrate, "minimum bitrate in kbps, tests end below it")
+	duration := flags.Duration("duration", defaults.MaxTestDuration, "maximum duration of a test")
+	strategyName := flags.String("strategy", "linear", "bitrate search strategy: linear, binary or exponential")
+	results := flags.String("results", "", "JSON lines file completed tests are appended to, disabled if empty")
+	admin := flags.String("admin", "", "listen address of the admin API and metrics, such as localhost:8001, disabled if empty")
+	authTokens := flags.String("auth-tokens", "", "file of accepted bearer tokens, one per line, enables authentication")
+	authKey := flags.String("auth-key", "", "file holding the HMAC key of signed URLs (see litmus sign), enables authentication")
+	tokenKey := flags.String("token-key", "", "file holding the HMAC key result tokens are signed with, disabled if empty")
+	tokenTTL := flags.Duration("token-ttl", time.Hour, "validity of result tokens")
+	egressBudget := flags.Int("egress-budget", 0, "kbps shared by concurrent download tests, each reserving its max bitrate; unlimited if 0")
+	queue := flags.Int("queue", 0, "offers that can wait for egress budget instead of being rejected, requires -egress-budget")
+	priorityParam := flags.String("priority-param", "", "query parameter holding the waiting room priority class, trust it with -auth-key only")
+	limitSessions := flags.Int("limit-sessions", 0, "concurrent sessions per client IP, unlimited if 0")
+	limitTests := flags.Int("limit-tests", 0, "sessions per client IP per hour, unlimited if 0")
+	limitMB := flags.Int64("limit-mb", 0, "megabytes of test traffic sent per client IP per day, unlimited if 0")
+	trustedProxies := flags.String("trusted-proxies", "", "comma separated proxy addresses or networks whose X-Forwarded-For header is trusted")
+	emulate := flags.String("emulate", "", `shape test traffic as if it crossed this link, such as "5mbit,40ms,1%loss"`)
+	drain := flags.Duration("drain", 30*time.Second, "on SIGINT/SIGTERM, how long running tests may finish before they are cut off")
+	flags.Parse(args)
+
+	if (*cert == "") != (*key == "") {
+		fmt.Fprintln(os.Stderr, "-cert and -key must be used together")
+		return 2
+	}
+
+	strategy, ok := litmus.ParseStrategy(*strategyName)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown strategy %q\n", *strategyName)
+		return 2
+	}
+
+	opts := []litmus.Option{
+		litmus.WithICEServers(iceServers(*ice)...),
+		litmus.WithTunerBounds(*initial, *max, *step),
+		litmus.WithMinBitrate(*min),
+		litmus.WithMaxTestDuration(*duration),
+		litmus.WithStrategy(strategy),
+		litmus.WithEgressBudget(*egressBudget),
+		litmus.WithWaitingRoom(*queue),
+		litmus.WithRateLimits(litmus.RateLimits{
+			Sessions:     *limitSessions,
+			TestsPerHour: *limitTests,
+			BytesPerDay:  *limitMB * 1000 * 1000,
+		}),
+	}
+	if *priorityParam != "" {
+		opts = append(opts, litmus.WithPrioritizer(litmus.QueryPriority(*priorityParam)))
+	}
+	if *origins != "" {
+		opts = append(opts, litmus.WithAllowedOrigins(strings.Split(*origins, ",")...))
+	}
+
+	switch {
+	case *authTokens != "" && *authKey != "":
+		fmt.Fprintln(os.Stderr, "-auth-tokens and -auth-key are exclusive")
+		return 2
+	case *authTokens != "":
+		data, err := os.ReadFile(*authTokens)
+		if err != nil {
+			Err(Critical, "network litmus auth tokens", err)
+			return 1
+		}
+		tokens := strings.Fields(string(data))
+		if len(tokens) == 0 {
+			fmt.Fprintln(os.Stderr, "-auth-tokens: no tokens in file")
+			return 2
+		}
+		opts = append(opts, litmus.WithAuthenticator(litmus.BearerTokens(tokens...)))
+	case *authKey != "":
+		key, err := readKey(*authKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-auth-key:", err)
+			return 2
+		}
+		opts = append(opts, litmus.WithAuthenticator(litmus.SignedURLs(key)))
+	}
+
+	if *tokenKey != "" {
+		key, err := readKey(*tokenKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-token-key:", err)
+			return 2
+		}
+		opts = append(opts, litmus.WithResultTokens(key, *tokenTTL))
+	}
+
+	if *trustedProxies != "" {
+		proxies, err := parsePrefixes(*trustedProxies)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-trusted-proxies:", err)
+			return 2
+		}
+		opts = append(opts, litmus.WithTrustedProxies(proxies...))
+	}
+
+	if *emulate != "" {
+		impairment, err := litmus.ParseImpairment(*emulate)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-emulate:", err)
+			return 2
+		}
+		opts = append(opts, litmus.WithImpairment(impairment))
+	}
+
+	if *results != "" {
+		store, err := litmus.NewFileStore(*results)
+		if err != nil {
+			Err(Critical, "network litmus result store", err)
+			return 1
+		}
+		defer store.Close()
+		opts = append(opts, litmus.WithResultStore(store))
+	}
+
+	server := litmus.NewServer(*port, opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	if *cert != "" {
+		err = server.StartTLS(*path, *cert, *key)
+	} else {
+		err = server.Start(*path)
+	}
+	if err != nil {
+		Err(Critical, "network litmus server listen", err)
+		return 1
+	}
+
+	Log(Info, "Litmus server online.", Entry{"port", *port}, Entry{"tls", *cert != ""})
+
+	if *admin != "" {
+		mux := http.NewServeMux()
+		server.RegisterAdminHandlers(mux, *path)
+		go func() {
+			if err := http.ListenAndServe(*admin, mux); err != nil {
+				Err(Error, "network litmus admin listen", err)
+			}
+		}()
+		Log(Info, "Litmus admin API online.", Entry{"addr", *admin})
+	}
+
+	<-ctx.Done()
+	stop() // a second signal kills the process
+
+	Log(Info, "Litmus server draining.", Entry{"timeout", *drain})
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), *drain)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		Err(Warning, "network litmus server shutdown", err)
+		return 1
+	}
+	return 0
+}
+
+// readKey reads an HMAC key from a file, surrounding whitespace excluded
+func readKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%s: empty key", path)
+	}
+	return key, nil
+}
+
+// parsePrefixes parses a comma separated list of networks, bare addresses standing for themselves alone
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kickback-space/litmus"
+)
+
+func sign(args []string) int {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyFile := flags.String("key", "", "file holding the HMAC key, as given to litmus serve -auth-key")
+	ttl := flags.Duration("ttl", 10*time.Minute, "validity of the signed URL")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: litmus sign -key file [flags] <url>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *keyFile == "" {
+		flags.Usage()
+		return 2
+	}
+
+	key, err := readKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-key:", err)
+		return 2
+	}
+
+	signed, err := litmus.SignURL(key, testURL(flags.Arg(0)), time.Now().Add(*ttl))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sign:", err)
+		return 1
+	}
+	fmt.Println(signed)
+	return 0
+}
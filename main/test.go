@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kickback-space/litmus"
+	"github.com/kickback-space/litmus/client"
+)
+
+func test(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	directionName := flags.String("direction", "download", "test direction: download, upload or both")
+	ice := flags.String("ice", "stun:stun.l.google.com:19302", "comma separated ICE server URLs")
+	timeout := flags.Duration("timeout", litmus.DefaultConfig().MaxTestDuration*2+30*time.Second, "overall test timeout")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	verbose := flags.Bool("v", false, "print bitrate updates while testing")
+	initial := flags.Int("initial", 0, "requested initial bitrate in kbps, server default if 0")
+	max := flags.Int("max", 0, "requested maximum bitrate in kbps, server default if 0")
+	step := flags.Int("step", 0, "requested bitrate step in kbps, server default if 0")
+	budget := flags.Duration("budget", 0, "requested total test duration, server default if 0")
+	strategyName := flags.String("strategy", "", "requested search strategy: linear, binary or exponential")
+	token := flags.String("token", "", "bearer token sent to servers requiring authentication")
+	timelineFile := flags.String("timeline", "", "write the test timeline to this file, as JSON if it ends in .json and CSV otherwise")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: litmus test [flags] <url>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	direction, ok := litmus.ParseDirection(*directionName)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown direction %q\n", *directionName)
+		return 2
+	}
+
+	if _, ok := litmus.ParseStrategy(*strategyName); !ok {
+		fmt.Fprintf(os.Stderr, "unknown strategy %q\n", *strategyName)
+		return 2
+	}
+
+	opts := []client.Option{
+		client.WithDirection(direction),
+		client.WithICEServers(iceServers(*ice)...),
+		client.WithParameters(litmus.TestParameters{
+			InitialBitrate: *initial,
+			MaxBitrate:     *max,
+			StepSize:       *step,
+			TimeBudget:     int(budget.Milliseconds()),
+			Strategy:       *strategyName,
+		}),
+	}
+	if *token != "" {
+		opts = append(opts, client.WithHeader(http.Header{
+			"Authorization": []string{"Bearer " + *token},
+		}))
+	}
+	if *verbose {
+		opts = append(opts, client.WithBitrateUpdate(func(d litmus.Direction, bitrate int) {
+			fmt.Fprintf(os.Stderr, "%s: %d kbps\n", d, bitrate)
+		}), client.WithQueuePosition(func(position, waiting int) {
+			fmt.Fprintf(os.Stderr, "waiting: %d of %d\n", position, waiting)
+		}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	result, err := client.New(opts...).Run(ctx, testURL(flags.Arg(0)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "test failed:", err)
+		var protocolErr *litmus.ProtocolError
+		if errors.As(err, &protocolErr) && protocolErr.RetryAfter > 0 {
+			fmt.Fprintln(os.Stderr, "retry after", protocolErr.RetryAfter)
+		}
+		return 1
+	}
+
+	if *timelineFile != "" {
+		if err := writeTimeline(*timelineFile, result.Timeline); err != nil {
+			fmt.Fprintln(os.Stderr, "timeline not written:", err)
+			return 1
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return 0
+	}
+
+	printResult(os.Stdout, result)
+	return 0
+}
+
+func writeTimeline(path string, timeline litmus.Timeline) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".json") {
+		err = timeline.WriteJSON(f)
+	} else {
+		err = timeline.WriteCSV(f)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// testURL accepts http(s) and bare host URLs for convenience, and appends the /litmus endpoint if missing
+func testURL(url string) string {
+	switch {
+	case strings.HasPrefix(url, "http://"):
+		url = "ws://" + strings.TrimPrefix(url, "http://")
+	case strings.HasPrefix(url, "https://"):
+		url = "wss://" + strings.TrimPrefix(url, "https://")
+	case !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://"):
+		url = "ws://" + url
+	}
+
+	url, query, hasQuery := strings.Cut(url, "?")
+	if !strings.HasSuffix(url, "/litmus") {
+		url = strings.TrimSuffix(url, "/") + "/litmus"
+	}
+	if hasQuery {
+		url += "?" + query
+	}
+	return url
+}
+
+func printResult(w io.Writer, result *client.Result) {
+	printCapability := func(name string, c *litmus.NetworkCapability) {
+		if c == nil {
+			return
+		}
+		fmt.Fprintf(w, "%-9s %6d kbps  loss %.2f%%  jitter %.1f ms\n", name+":", c.MaxStableBitrate, c.PacketLossRate*100, c.Jitter)
+	}
+	printCapability("download", result.Download)
+	printCapability("upload", result.Upload)
+
+	printRTT := func(name string, r litmus.RTTStats) {
+		if r.Samples == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%-9s min %.1f ms  avg %.1f ms  p95 %.1f ms\n", name+":", r.Min, r.Avg, r.P95)
+	}
+	printRTT("rtt idle", result.IdleRTT)
+	printRTT("rtt load", result.LoadedRTT)
+	if result.Bufferbloat.Grade != "" {
+		fmt.Fprintf(w, "bufferbloat: %s (+%.1f ms under load)\n", result.Bufferbloat.Grade, result.Bufferbloat.AddedLatency)
+	}
+
+	profile := result.Profile
+	if profile == "" {
+		profile = "none"
+	}
+	fmt.Fprintf(w, "profile:  %s\n", profile)
+
+	for _, eval := range result.Failed {
+		fmt.Fprintf(w, "  %s failed: %s\n", eval.Profile, strings.Join(eval.Reasons, "; "))
+	}
+
+	if result.Token != "" {
+		fmt.Fprintf(w, "token:    %s\n", result.Token)
+	}
+}
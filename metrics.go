@@ -0,0 +1,147 @@
+package litmus
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const lossWindow = time.Second
+
+type receivedPacket struct {
+	sequence uint32
+	at       time.Time
+}
+
+// ReceiverMetrics computes loss, jitter and throughput of a test packet stream on the receiving side.
+// It mirrors the browser MetricsManager so that both directions are measured the same way.
+//
+// Not concurrent safe.
+type ReceiverMetrics struct {
+	reportInterval   time.Duration
+	window           []receivedPacket
+	lastArrival      time.Time
+	lastInterarrival float64 // milliseconds
+	jitter           float64 // milliseconds
+	lossRate         float64
+	bytesSinceReport int
+	lastReport       time.Time
+
+	// sequence span of the current report interval, used to estimate the sender rate
+	lowestSequence  uint32
+	highestSequence uint32
+	packetsInReport int
+}
+
+// NewReceiverMetrics creates a ReceiverMetrics that produces a report every reportInterval
+func NewReceiverMetrics(reportInterval time.Duration) *ReceiverMetrics {
+	return &ReceiverMetrics{
+		reportInterval: reportInterval,
+	}
+}
+
+// Observe records a packet that arrived at the given time.
+// Returns a Measurement and true once reportInterval has passed since the previous report.
+// The ServerEffectiveRate of the Measurement is the sender rate estimated from the sequence numbers seen.
+func (rm *ReceiverMetrics) Observe(packet []byte, at time.Time) (Measurement, bool) {
+	if len(packet) < headerSize {
+		return Measurement{}, false
+	}
+	sequence := binary.BigEndian.Uint32(packet[0 : headerSize-8])
+
+	rm.bytesSinceReport += len(packet)
+	if rm.packetsInReport == 0 || sequence < rm.lowestSequence {
+		rm.lowestSequence = sequence
+	}
+	if rm.packetsInReport == 0 || sequence > rm.highestSequence {
+		rm.highestSequence = sequence
+	}
+	rm.packetsInReport++
+
+	rm.updatePacketLoss(sequence, at)
+	rm.updateJitter(at)
+
+	if !rm.lastReport.IsZero() && at.Sub(rm.lastReport) < rm.reportInterval {
+		return Measurement{}, false
+	}
+
+	elapsedMs := float64(rm.reportInterval.Milliseconds())
+	if !rm.lastReport.IsZero() {
+		elapsedMs = float64(at.Sub(rm.lastReport).Milliseconds())
+	}
+	if elapsedMs <= 0 {
+		elapsedMs = 1
+	}
+
+	bitsReceived := float64(rm.bytesSinceReport) * 8
+	averagePacketBits := bitsReceived / float64(rm.packetsInReport)
+	packetsSent := float64(rm.highestSequence-rm.lowestSequence) + 1
+
+	m := Measurement{
+		LossRate:            rm.lossRate,
+		Jitter:              rm.jitter,
+		ActualThroughput:    bitsReceived * 1000 / elapsedMs, // bits/second
+		ServerEffectiveRate: packetsSent * averagePacketBits * 1000 / elapsedMs,
+	}
+
+	rm.lastReport = at
+	rm.bytesSinceReport = 0
+	rm.packetsInReport = 0
+	return m, true
+}
+
+// Jitter returns the current jitter estimate in milliseconds
+func (rm *ReceiverMetrics) Jitter() float64 {
+	return rm.jitter
+}
+
+// LossRate returns the current packet loss estimate over the last second
+func (rm *ReceiverMetrics) LossRate() float64 {
+	return rm.lossRate
+}
+
+func (rm *ReceiverMetrics) updateJitter(at time.Time) {
+	interarrival := 0.0
+	if !rm.lastArrival.IsZero() {
+		interarrival = float64(at.Sub(rm.lastArrival).Microseconds()) / 1000
+		jitterDelta := math.Abs(interarrival - rm.lastInterarrival)
+
+		// RFC 3550 jitter formula with exponential moving average
+		rm.jitter += (jitterDelta - rm.jitter) / 16
+	}
+
+	rm.lastInterarrival = interarrival
+	rm.lastArrival = at
+}
+
+func (rm *ReceiverMetrics) updatePacketLoss(sequence uint32, at time.Time) {
+	rm.window = append(rm.window, receivedPacket{sequence, at})
+
+	windowStart := at.Add(-lossWindow)
+	i := 0
+	for i < len(rm.window) && !rm.window[i].at.After(windowStart) {
+		i++
+	}
+	rm.window = rm.window[i:]
+
+	unique := make(map[uint32]struct{}, len(rm.window))
+	var lowest, highest uint32
+	for j, packet := range rm.window {
+		unique[packet.sequence] = struct{}{}
+		if j == 0 || packet.sequence < lowest {
+			lowest = packet.sequence
+		}
+		if j == 0 || packet.sequence > highest {
+			highest = packet.sequence
+		}
+	}
+
+	if len(unique) == 0 {
+		rm.lossRate = 0
+		return
+	}
+
+	expectedPackets := float64(highest-lowest) + 1
+	receivedPackets := float64(len(unique))
+	rm.lossRate = math.Max(0, (expectedPackets-receivedPackets)/expectedPackets)
+}
@@ -4,7 +4,6 @@ package litmus
 import (
 	"sync"
 	"time"
-	"math"
 )
 
 const (
@@ -22,32 +21,44 @@ const (
 
 // NetworkCapability represents the measured network performance characteristics
 type NetworkCapability struct {
-	MaxStableBitrate  int     // kbps
-	PacketLossRate    float64 // Measured packet loss rate
-	Jitter           float64  // Measured jitter in milliseconds
+	MaxStableBitrate  int     `json:"max_stable_bitrate"` // kbps
+	PacketLossRate    float64 `json:"packet_loss_rate"`   // Measured packet loss rate
+	Jitter           float64  `json:"jitter"`             // Measured jitter in milliseconds
+	IdleRTT          RTTStats `json:"idle_rtt"`           // Round trip time before the test stream started
+	LoadedRTT        RTTStats `json:"loaded_rtt"`         // Round trip time while the test stream was saturating the link
+	Bufferbloat      Bufferbloat `json:"bufferbloat"`     // Queueing delay added under load
 }
 
-// NetworkTuner manages the network capability discovery process
+// NetworkTuner manages the network capability discovery process.
+// The search itself is delegated to a BitrateStrategy, the tuner paces it and keeps it concurrent safe.
 type NetworkTuner struct {
-	currentBitrate      int
-	maxBitrate         int
-	stepSize           int
+	strategy           BitrateStrategy
 	lastAdjustment     time.Time
-	stableCount        int
-	failureCount       int
-	deviationCount     int
+	adaptInterval      time.Duration
 	testComplete       bool
-	bestStable         NetworkCapability
 	mu                 sync.Mutex
 	serverEffectiveRate float64
+	clock              Clock
 }
 
+// NewNetworkTuner creates a tuner using the default linear search
 func NewNetworkTuner(initialBitrate, maxBitrate, stepSize int) *NetworkTuner {
+	strategy := newLinearStrategy(initialBitrate, maxBitrate, stepSize, 1000, DefaultThresholds())
+	return NewNetworkTunerWithStrategy(strategy, adaptInterval)
+}
+
+// NewNetworkTunerWithStrategy creates a tuner driven by strategy, adjusting at most once per interval
+func NewNetworkTunerWithStrategy(strategy BitrateStrategy, interval time.Duration) *NetworkTuner {
+	return NewNetworkTunerWithClock(strategy, interval, WallClock)
+}
+
+// NewNetworkTunerWithClock is NewNetworkTunerWithStrategy with the adjustment interval measured on clock
+func NewNetworkTunerWithClock(strategy BitrateStrategy, interval time.Duration, clock Clock) *NetworkTuner {
 	return &NetworkTuner{
-		currentBitrate: initialBitrate,
-		maxBitrate:    maxBitrate,
-		stepSize:      stepSize,
-		lastAdjustment: time.Now(),
+		strategy:       strategy,
+		lastAdjustment: clock.Now(),
+		adaptInterval:  interval,
+		clock:          clock,
 	}
 }
 
@@ -66,7 +77,7 @@ func (nt *NetworkTuner) GetServerEffectiveRate() float64 {
 func (nt *NetworkTuner) getCurrentBitrate() int {
 	nt.mu.Lock()
 	defer nt.mu.Unlock()
-	return nt.currentBitrate
+	return nt.strategy.Bitrate()
 }
 
 func (nt *NetworkTuner) IsTestComplete() bool {
@@ -75,118 +86,55 @@ func (nt *NetworkTuner) IsTestComplete() bool {
 	return nt.testComplete
 }
 
+// stop ends the search early, when its test phase ran out of time. Later measurements are ignored.
+func (nt *NetworkTuner) stop() {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	nt.testComplete = true
+}
+
 func (nt *NetworkTuner) GetCapability() NetworkCapability {
 	nt.mu.Lock()
 	defer nt.mu.Unlock()
-	return nt.bestStable
+	return nt.strategy.Best()
 }
 
 func (nt *NetworkTuner) adjustBitrate(lossRate, jitter, actualThroughput, serverEffectiveRate float64) bool {
+	return nt.adjust(Measurement{
+		LossRate:            lossRate,
+		Jitter:              jitter,
+		ActualThroughput:    actualThroughput,
+		ServerEffectiveRate: serverEffectiveRate,
+	}) != DecisionComplete
+}
+
+// adjust feeds one measurement to the strategy and reports what came of it
+func (nt *NetworkTuner) adjust(m Measurement) Decision {
 	nt.mu.Lock()
 	defer nt.mu.Unlock()
 
-	now := time.Now()
-	if now.Sub(nt.lastAdjustment) < adaptInterval {
-		return !nt.testComplete
-	}
-	nt.lastAdjustment = now
-
-	clientToServerEffectiveRatio := 0.0
-	if serverEffectiveRate > 0 {
-		clientToServerEffectiveRatio = (actualThroughput / serverEffectiveRate) * 100
+	if nt.testComplete {
+		return DecisionComplete
 	}
 
-	targetBitrateInBps := float64(nt.currentBitrate) * 1000
-
-	// Calculate deviation of server effective rate from target bitrate
-	effectiveRateDeviation := 0.0
-	if serverEffectiveRate > 0 {
-		effectiveRateDeviation = math.Abs((targetBitrateInBps - serverEffectiveRate) / targetBitrateInBps * 100)
+	now := nt.clock.Now()
+	if now.Sub(nt.lastAdjustment) < nt.adaptInterval {
+		return DecisionSkipped
 	}
+	nt.lastAdjustment = now
 
-	if effectiveRateDeviation > MaxEffectiveRateDeviation {
-		nt.deviationCount++
-
-		// If we see consistent high deviation, step down regardless of other metrics
-		if nt.deviationCount >= requiredDeviationIntervals {
-			// Step down bitrate
-			newBitrate := int(serverEffectiveRate / 1000) // Convert to kbps
-			
-			if newBitrate < nt.currentBitrate-nt.stepSize {
-				newBitrate = nt.currentBitrate - nt.stepSize
-			}
-			
-			nt.currentBitrate = newBitrate
-			nt.deviationCount = 0
-			nt.stableCount = 0
-			
-			if nt.currentBitrate < 1000 { // Minimum 1 Mbps
-				nt.testComplete = true
-				return false
-			}
-			
-			nt.bestStable = NetworkCapability{
-				MaxStableBitrate: newBitrate,
-				PacketLossRate:   lossRate,
-				Jitter:           jitter,
-			}
-			
-			return true
-		}
-	} else {
-		nt.deviationCount = 0
+	before := nt.strategy.Bitrate()
+	if !nt.strategy.Update(m) {
+		nt.testComplete = true
+		return DecisionComplete
 	}
 
-	// Check if current bitrate is stable
-	if lossRate <= MaxStablePacketLossRate && 
-	   jitter <= MaxStableJitter && 
-	   effectiveRateDeviation <= MaxEffectiveRateDeviation &&
-	   clientToServerEffectiveRatio >= MinimumThroughputRatio {
-		nt.stableCount++
-		nt.failureCount = 0
-
-		if nt.stableCount >= requiredStableIntervals {
-			currentCapability := NetworkCapability{
-				MaxStableBitrate: nt.currentBitrate,
-				PacketLossRate:   lossRate,
-				Jitter:           jitter,
-			}
-
-			if nt.bestStable.MaxStableBitrate > 0 {
-				lastThroughput := float64(nt.bestStable.MaxStableBitrate)
-				currentThroughput := float64(currentCapability.MaxStableBitrate)
-
-				if currentThroughput < lastThroughput*1.05 {
-					nt.testComplete = true
-					return false
-				}
-			}
-
-			nt.bestStable = currentCapability
-
-			// Try higher bitrate if not at max and deviation is low
-			if nt.currentBitrate < nt.maxBitrate && effectiveRateDeviation < StepUpEffectiveDeviation {
-				nt.currentBitrate += nt.stepSize
-				nt.stableCount = 0
-			} else {
-				// We reached maxBitrate or high deviation
-				nt.testComplete = true
-				return false
-			}
-		}
-	} else {
-		nt.failureCount++
-		nt.stableCount = 0
-
-		if nt.failureCount >= requiredFailureIntervals {
-			nt.currentBitrate -= nt.stepSize
-			if nt.currentBitrate < 1000 { // Minimum 1 Mbps
-				nt.testComplete = true
-				return false
-			}
-			nt.failureCount = 0
-		}
+	switch after := nt.strategy.Bitrate(); {
+	case after > before:
+		return DecisionIncrease
+	case after < before:
+		return DecisionDecrease
+	default:
+		return DecisionHold
 	}
-
-	return !nt.testComplete
-}
\ No newline at end of file
+}
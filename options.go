@@ -0,0 +1,289 @@
+package litmus
+
+import (
+	"net/http"
+	"net/netip"
+	"time"
+
+	. "github.com/blitz-frost/log"
+	"github.com/pion/webrtc/v3"
+)
+
+// Thresholds controls when the NetworkTuner considers an interval stable
+type Thresholds struct {
+	MaxPacketLossRate         float64 // fraction, e.g. 0.01 for 1%
+	MaxJitter                 float64 // milliseconds
+	MaxEffectiveRateDeviation float64 // percent
+	StepUpEffectiveDeviation  float64 // percent threshold for stepping up
+	MinimumThroughputRatio    float64 // min throughput between server & client
+	StableIntervals           int     // consecutive stable intervals before stepping up
+	FailureIntervals          int     // consecutive failed intervals before stepping down
+	DeviationIntervals        int     // consecutive high deviation intervals before stepping down
+}
+
+// DefaultThresholds returns the thresholds built from the package constants
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		MaxPacketLossRate:         MaxStablePacketLossRate,
+		MaxJitter:                 MaxStableJitter,
+		MaxEffectiveRateDeviation: MaxEffectiveRateDeviation,
+		StepUpEffectiveDeviation:  StepUpEffectiveDeviation,
+		MinimumThroughputRatio:    MinimumThroughputRatio,
+		StableIntervals:           requiredStableIntervals,
+		FailureIntervals:          requiredFailureIntervals,
+		DeviationIntervals:        requiredDeviationIntervals,
+	}
+}
+
+// Config holds the per deployment settings of a Server
+type Config struct {
+	ICEServers      []webrtc.ICEServer
+	InitialBitrate  int // kbps
+	MaxBitrate      int // kbps
+	StepSize        int // kbps
+	MinBitrate      int // kbps, the test ends when the tuner drops below it
+	Thresholds      Thresholds
+	Strategy        StrategyFactory // nil uses LinearSearch
+	MaxTestDuration time.Duration
+	AdaptInterval   time.Duration
+	RTTInterval     time.Duration              // time between RTT probes, 0 disables RTT measurement
+	IdleRTTDuration time.Duration              // how long RTT is probed before the test stream starts
+	CheckOrigin     func(r *http.Request) bool // nil accepts all origins
+	Logger          Logger                     // nil uses the log package DefaultLogger
+	ResultStore     ResultStore                // completed tests are saved to it, nil disables persistence
+	Clock           Clock                      // time source of the tuners, timelines and rate limits, nil uses WallClock
+	API             *webrtc.API                // creates the peer connections, nil uses the pion defaults
+	EgressBudget    int                        // kbps, sum of the max bitrates of concurrent download tests, 0 for unlimited
+	QueueSize       int                        // offers that can wait for egress budget, 0 rejects them right away
+	Prioritize      Prioritizer                // priority class of waiting offers, nil puts all in class 0
+	Impairment      *Impairment                // emulated link applied to test traffic, nil for none
+	Authenticate    Authenticator              // checks requests before the websocket upgrade, nil accepts all
+	TokenKey        []byte                     // HMAC key signing result tokens, nil disables them
+	TokenTTL        time.Duration              // validity of result tokens, 0 uses one hour
+	RateLimits      RateLimits                 // per client IP usage caps, zero for none
+	TrustedProxies  []netip.Prefix             // proxies whose X-Forwarded-For header names the client IP
+}
+
+// DefaultConfig returns the configuration used by NewServer when no options are given
+func DefaultConfig() Config {
+	return Config{
+		ICEServers: []webrtc.ICEServer{
+			{
+				URLs: []string{"stun:stun.l.google.com:19302"},
+			},
+		},
+		InitialBitrate:  2000,
+		MaxBitrate:      15000,
+		StepSize:        1000,
+		MinBitrate:      1000,
+		Thresholds:      DefaultThresholds(),
+		MaxTestDuration: maxTestDuration,
+		AdaptInterval:   adaptInterval,
+		RTTInterval:     rttProbeInterval,
+		IdleRTTDuration: idleProbeDuration,
+	}
+}
+
+// Option configures a Server
+type Option func(*Config)
+
+// WithConfig replaces the whole configuration
+func WithConfig(c Config) Option {
+	return func(dst *Config) {
+		*dst = c
+	}
+}
+
+// WithICEServers sets the ICE servers offered to peer connections
+func WithICEServers(servers ...webrtc.ICEServer) Option {
+	return func(c *Config) {
+		c.ICEServers = servers
+	}
+}
+
+// WithTunerBounds sets the initial, maximum and step bitrates of the NetworkTuner, in kbps
+func WithTunerBounds(initial, max, step int) Option {
+	return func(c *Config) {
+		c.InitialBitrate = initial
+		c.MaxBitrate = max
+		c.StepSize = step
+	}
+}
+
+// WithMinBitrate sets the bitrate below which a test is considered complete, in kbps
+func WithMinBitrate(min int) Option {
+	return func(c *Config) {
+		c.MinBitrate = min
+	}
+}
+
+// WithThresholds sets the NetworkTuner stability thresholds
+func WithThresholds(t Thresholds) Option {
+	return func(c *Config) {
+		c.Thresholds = t
+	}
+}
+
+// WithStrategy sets the bitrate search strategy used for each session
+func WithStrategy(f StrategyFactory) Option {
+	return func(c *Config) {
+		c.Strategy = f
+	}
+}
+
+// WithMaxTestDuration sets the hard limit on a single test
+func WithMaxTestDuration(d time.Duration) Option {
+	return func(c *Config) {
+		c.MaxTestDuration = d
+	}
+}
+
+// WithAdaptInterval sets the minimum time between two bitrate adjustments
+func WithAdaptInterval(d time.Duration) Option {
+	return func(c *Config) {
+		c.AdaptInterval = d
+	}
+}
+
+// WithRTTProbing sets the RTT probe interval and the idle probing duration, an interval of 0 disables probing
+func WithRTTProbing(interval, idle time.Duration) Option {
+	return func(c *Config) {
+		c.RTTInterval = interval
+		c.IdleRTTDuration = idle
+	}
+}
+
+// WithCheckOrigin sets the websocket origin policy
+func WithCheckOrigin(f func(r *http.Request) bool) Option {
+	return func(c *Config) {
+		c.CheckOrigin = f
+	}
+}
+
+// WithAllowedOrigins only accepts websocket connections whose Origin header matches one of the given values
+func WithAllowedOrigins(origins ...string) Option {
+	allowed := make(map[string]struct{}, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = struct{}{}
+	}
+
+	return WithCheckOrigin(func(r *http.Request) bool {
+		_, ok := allowed[r.Header.Get("Origin")]
+		return ok
+	})
+}
+
+// WithAuthenticator rejects websocket requests that a does not accept, see BearerTokens and SignedURLs
+func WithAuthenticator(a Authenticator) Option {
+	return func(c *Config) {
+		c.Authenticate = a
+	}
+}
+
+// WithAPI sets the pion API peer connections are created with,
+// for example to run on a virtual network through its SettingEngine
+func WithAPI(api *webrtc.API) Option {
+	return func(c *Config) {
+		c.API = api
+	}
+}
+
+// WithResultTokens signs every test report with key, so that application servers can verify results with VerifyResult.
+// Tokens are valid for ttl, 0 uses one hour.
+func WithResultTokens(key []byte, ttl time.Duration) Option {
+	return func(c *Config) {
+		c.TokenKey = key
+		c.TokenTTL = ttl
+	}
+}
+
+// WithEgressBudget limits the sum of the maximum bitrates of concurrent download tests to budget kbps.
+// Offers that do not fit are rejected with an at_capacity error telling the client when to retry.
+func WithEgressBudget(budget int) Option {
+	return func(c *Config) {
+		c.EgressBudget = budget
+	}
+}
+
+// WithWaitingRoom lets up to size offers that do not fit the egress budget wait for their turn,
+// for clients that negotiate the queue feature. It has no effect without WithEgressBudget.
+func WithWaitingRoom(size int) Option {
+	return func(c *Config) {
+		c.QueueSize = size
+	}
+}
+
+// WithPrioritizer orders the waiting room by the priority class p assigns to each request, highest first
+func WithPrioritizer(p Prioritizer) Option {
+	return func(c *Config) {
+		c.Prioritize = p
+	}
+}
+
+// WithRateLimits caps the concurrent sessions, tests per hour and bytes per day of each client IP.
+// Requests over the session or test limits are answered with 429 before the upgrade,
+// tests running out of bytes end with a rate_limited error.
+func WithRateLimits(limits RateLimits) Option {
+	return func(c *Config) {
+		c.RateLimits = limits
+	}
+}
+
+// WithTrustedProxies attributes requests coming from the given networks to the client named by their
+// X-Forwarded-For header, for rate limits, results and tokens. Only list proxies that set the header themselves.
+func WithTrustedProxies(proxies ...netip.Prefix) Option {
+	return func(c *Config) {
+		c.TrustedProxies = proxies
+	}
+}
+
+// WithImpairment shapes the test traffic of every session in process as if it crossed the given link,
+// to see how clients behave on bad networks without touching the real one
+func WithImpairment(imp Impairment) Option {
+	return func(c *Config) {
+		c.Impairment = &imp
+	}
+}
+
+// WithClock sets the time source of the tuners, timelines and rate limits, mostly useful to replay recorded tests
+func WithClock(clock Clock) Option {
+	return func(c *Config) {
+		c.Clock = clock
+	}
+}
+
+// WithResultStore saves every completed test to store
+func WithResultStore(store ResultStore) Option {
+	return func(c *Config) {
+		c.ResultStore = store
+	}
+}
+
+// WithLogger sets the logger used by the server instead of the log package default
+func WithLogger(l Logger) Option {
+	return func(c *Config) {
+		c.Logger = l
+	}
+}
+
+func (c Config) newTuner() *NetworkTuner {
+	strategy := c.Strategy
+	if strategy == nil {
+		strategy = LinearSearch
+	}
+	return NewNetworkTunerWithClock(strategy(c), c.AdaptInterval, c.clock())
+}
+
+func (c Config) newPeerConnection(configuration webrtc.Configuration) (*webrtc.PeerConnection, error) {
+	if c.API != nil {
+		return c.API.NewPeerConnection(configuration)
+	}
+	return webrtc.NewPeerConnection(configuration)
+}
+
+func (c Config) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return WallClock
+}
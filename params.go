@@ -0,0 +1,93 @@
+package litmus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	minStepSize       = 100 // kbps
+	minTestDuration   = 2 * time.Second
+	maxTimeBudget     = time.Hour // well past any test, but small enough to count in milliseconds without overflowing
+	idleRTTBudgetPart = 10        // at most a tenth of a time budget is spent probing idle RTT
+)
+
+// TestParameters are requested by the client in its offer.
+// Zero values keep the server defaults, everything is clamped to the server configuration.
+type TestParameters struct {
+	InitialBitrate int    `json:"initial_bitrate,omitempty"` // kbps
+	MaxBitrate     int    `json:"max_bitrate,omitempty"`     // kbps
+	StepSize       int    `json:"step_size,omitempty"`       // kbps
+	TimeBudget     int    `json:"time_budget_ms,omitempty"`  // total duration of the test, all directions included
+	Strategy       string `json:"strategy,omitempty"`        // linear, binary or exponential
+}
+
+func (p TestParameters) validate() error {
+	if p.InitialBitrate < 0 || p.MaxBitrate < 0 || p.StepSize < 0 || p.TimeBudget < 0 {
+		return errors.New("parameters must not be negative")
+	}
+	if p.TimeBudget > int(maxTimeBudget/time.Millisecond) {
+		return fmt.Errorf("time budget must not exceed %s", maxTimeBudget)
+	}
+	if _, ok := ParseStrategy(p.Strategy); !ok {
+		return fmt.Errorf("unknown strategy %q", p.Strategy)
+	}
+	return nil
+}
+
+// clamp bounds v to [low, high]
+func clamp(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
+
+// ApplyParameters returns the configuration of a single test, narrowing c to what the client requested.
+// The server configuration acts as the limit: clients can ask for less, never for more.
+func (c Config) ApplyParameters(p TestParameters, direction Direction) (Config, error) {
+	if err := p.validate(); err != nil {
+		return c, err
+	}
+
+	if p.MaxBitrate > 0 {
+		c.MaxBitrate = clamp(p.MaxBitrate, c.MinBitrate, c.MaxBitrate)
+	}
+	if p.InitialBitrate > 0 {
+		c.InitialBitrate = p.InitialBitrate
+	}
+	c.InitialBitrate = clamp(c.InitialBitrate, c.MinBitrate, c.MaxBitrate)
+	if p.StepSize > 0 {
+		c.StepSize = clamp(p.StepSize, minStepSize, c.MaxBitrate)
+	}
+	if p.Strategy != "" {
+		c.Strategy, _ = ParseStrategy(p.Strategy)
+	}
+
+	if p.TimeBudget > 0 {
+		budget := time.Duration(p.TimeBudget) * time.Millisecond
+
+		if idle := budget / idleRTTBudgetPart; c.IdleRTTDuration > idle {
+			c.IdleRTTDuration = idle
+		}
+
+		// MaxTestDuration applies per direction, the budget covers the whole test
+		phases := time.Duration(1)
+		if direction == DirectionBoth {
+			phases = 2
+		}
+		phase := (budget - c.IdleRTTDuration) / phases
+		if phase < minTestDuration {
+			phase = minTestDuration
+		}
+		if phase < c.MaxTestDuration {
+			c.MaxTestDuration = phase
+		}
+	}
+
+	return c, nil
+}
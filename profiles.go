@@ -1,5 +1,10 @@
 package litmus
 
+import (
+	"fmt"
+	"sort"
+)
+
 type VideoProfile struct {
 	Name                 string
 	Resolution           string
@@ -136,4 +141,87 @@ func initProfiles() {
 		VideoProfiles[i].PacketSize = packetSize
 		VideoProfiles[i].PacketsPerSecond = packetsPerSecond
 	}
-}
\ No newline at end of file
+}
+
+// ProfileEvaluation is the outcome of checking a single VideoProfile against a measured NetworkCapability
+type ProfileEvaluation struct {
+	Profile string   `json:"profile"`
+	Passed  bool     `json:"passed"`
+	Reasons []string `json:"reasons,omitempty"` // why the profile failed, empty when passed
+}
+
+// ProfileRecommendation holds the result of evaluating every VideoProfile against a NetworkCapability
+type ProfileRecommendation struct {
+	Profile *VideoProfile // highest sustainable profile, nil if none passed
+	Passed  []ProfileEvaluation
+	Failed  []ProfileEvaluation
+}
+
+// EvaluateProfile checks whether a profile can be sustained with the given capability
+func EvaluateProfile(p *VideoProfile, capability NetworkCapability) ProfileEvaluation {
+	eval := ProfileEvaluation{Profile: p.Name}
+
+	// Bufferbloat penalizes the bitrate that can be relied on for real time video
+	usableBitrate := int(float64(capability.MaxStableBitrate) * capability.Bufferbloat.UsableRatio())
+	if usableBitrate < p.Bitrate {
+		if usableBitrate < capability.MaxStableBitrate {
+			eval.Reasons = append(eval.Reasons, fmt.Sprintf("bitrate %d kbps, reduced to %d kbps by bufferbloat grade %s, below required %d kbps", capability.MaxStableBitrate, usableBitrate, capability.Bufferbloat.Grade, p.Bitrate))
+		} else {
+			eval.Reasons = append(eval.Reasons, fmt.Sprintf("bitrate %d kbps below required %d kbps", capability.MaxStableBitrate, p.Bitrate))
+		}
+	}
+	if capability.PacketLossRate > p.AcceptablePacketLoss {
+		eval.Reasons = append(eval.Reasons, fmt.Sprintf("packet loss %.2f%% above acceptable %.2f%%", capability.PacketLossRate*100, p.AcceptablePacketLoss*100))
+	}
+	if capability.Jitter > p.AcceptableJitter {
+		eval.Reasons = append(eval.Reasons, fmt.Sprintf("jitter %.1f ms above acceptable %.1f ms", capability.Jitter, p.AcceptableJitter))
+	}
+
+	eval.Passed = len(eval.Reasons) == 0
+	return eval
+}
+
+// RecommendProfile evaluates every VideoProfile against the capability and picks the highest sustainable one.
+// Profiles are considered in order of descending bitrate.
+func RecommendProfile(capability NetworkCapability) ProfileRecommendation {
+	profiles := make([]*VideoProfile, len(VideoProfiles))
+	for i := range VideoProfiles {
+		profiles[i] = &VideoProfiles[i]
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Bitrate > profiles[j].Bitrate
+	})
+
+	var rec ProfileRecommendation
+	for _, p := range profiles {
+		eval := EvaluateProfile(p, capability)
+		if !eval.Passed {
+			rec.Failed = append(rec.Failed, eval)
+			continue
+		}
+
+		rec.Passed = append(rec.Passed, eval)
+		if rec.Profile == nil {
+			rec.Profile = p
+		}
+	}
+
+	return rec
+}
+
+// ProfileName returns the name of the recommended profile, or an empty string if none passed
+func (r ProfileRecommendation) ProfileName() string {
+	if r.Profile == nil {
+		return ""
+	}
+	return r.Profile.Name
+}
+
+// PassedNames returns the names of all profiles that passed
+func (r ProfileRecommendation) PassedNames() []string {
+	names := make([]string, len(r.Passed))
+	for i, eval := range r.Passed {
+		names[i] = eval.Profile
+	}
+	return names
+}
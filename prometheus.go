@@ -0,0 +1,260 @@
+package litmus
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session failure reasons, the reason label of litmus_sessions_failed_total
+const (
+	FailureDownload         = "download"          // download phase failed
+	FailureUpload           = "upload"            // upload phase failed
+	FailureNegotiation      = "negotiation"       // offer or candidates rejected
+	FailureConnectionFailed = "connection_failed" // the peer connection failed
+	FailureTerminated       = CodeTerminated      // terminated through the admin API
+	FailureShuttingDown     = CodeShuttingDown    // cut off by Shutdown
+	FailureAborted          = "aborted"           // the client went away
+	FailureRateLimited      = CodeRateLimited     // the client IP used up its daily byte quota
+)
+
+var (
+	bitrateBuckets  = []float64{500, 1000, 2000, 3000, 4000, 5000, 6000, 8000, 10000, 15000, 20000, 30000, 50000} // kbps
+	durationBuckets = []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 90, 120, 200}                                    // seconds
+)
+
+// histogram is a fixed bucket histogram in the Prometheus sense
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// write writes the cumulative buckets, sum and count of the histogram. labels are prepended to le.
+func (h *histogram) write(w io.Writer, name, labels string) {
+	separator := ""
+	if labels != "" {
+		separator = ","
+	}
+
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, separator, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, separator, h.count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+type tunerStep struct {
+	direction Direction
+	up        bool
+}
+
+// serverMetrics aggregates the counters exported on the metrics endpoint
+type serverMetrics struct {
+	egressBytes atomic.Uint64 // updated per packet, kept out of the mutex
+
+	mu        sync.Mutex
+	started   uint64
+	completed uint64
+	failed    map[string]uint64
+	rejected  map[string]uint64
+	bitrate   map[Direction]*histogram
+	duration  *histogram
+	steps     map[tunerStep]uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		failed:   make(map[string]uint64),
+		rejected: make(map[string]uint64),
+		bitrate: map[Direction]*histogram{
+			DirectionDownload: newHistogram(bitrateBuckets),
+			DirectionUpload:   newHistogram(bitrateBuckets),
+		},
+		duration: newHistogram(durationBuckets),
+		steps:    make(map[tunerStep]uint64),
+	}
+}
+
+func (m *serverMetrics) sessionStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started++
+}
+
+func (m *serverMetrics) sessionCompleted(result TestResult, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.completed++
+	m.duration.observe(duration.Seconds())
+	if result.Download != nil {
+		m.bitrate[DirectionDownload].observe(float64(result.Download.MaxStableBitrate))
+	}
+	if result.Upload != nil {
+		m.bitrate[DirectionUpload].observe(float64(result.Upload.MaxStableBitrate))
+	}
+}
+
+func (m *serverMetrics) sessionFailed(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed[reason]++
+}
+
+func (m *serverMetrics) sessionRejected(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejected[reason]++
+}
+
+func (m *serverMetrics) tunerStepped(direction Direction, up bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps[tunerStep{direction, up}]++
+}
+
+// write writes all metrics in the Prometheus text exposition format
+func (m *serverMetrics) write(w io.Writer, activeSessions, reservedEgress, egressBudget, waitingSessions int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP litmus_active_sessions Test connections currently open.")
+	fmt.Fprintln(w, "# TYPE litmus_active_sessions gauge")
+	fmt.Fprintf(w, "litmus_active_sessions %d\n", activeSessions)
+
+	fmt.Fprintln(w, "# HELP litmus_sessions_started_total Tests started by an offer.")
+	fmt.Fprintln(w, "# TYPE litmus_sessions_started_total counter")
+	fmt.Fprintf(w, "litmus_sessions_started_total %d\n", m.started)
+
+	fmt.Fprintln(w, "# HELP litmus_sessions_completed_total Tests that reported a result.")
+	fmt.Fprintln(w, "# TYPE litmus_sessions_completed_total counter")
+	fmt.Fprintf(w, "litmus_sessions_completed_total %d\n", m.completed)
+
+	fmt.Fprintln(w, "# HELP litmus_sessions_failed_total Tests that ended without a result, by reason.")
+	fmt.Fprintln(w, "# TYPE litmus_sessions_failed_total counter")
+	reasons := make([]string, 0, len(m.failed))
+	for reason := range m.failed {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "litmus_sessions_failed_total{reason=%q} %d\n", reason, m.failed[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP litmus_sessions_rejected_total Connections and offers refused before the test started, by reason.")
+	fmt.Fprintln(w, "# TYPE litmus_sessions_rejected_total counter")
+	reasons = reasons[:0]
+	for reason := range m.rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "litmus_sessions_rejected_total{reason=%q} %d\n", reason, m.rejected[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP litmus_max_stable_bitrate_kbps Measured max stable bitrate of completed tests, by direction.")
+	fmt.Fprintln(w, "# TYPE litmus_max_stable_bitrate_kbps histogram")
+	for _, direction := range []Direction{DirectionDownload, DirectionUpload} {
+		m.bitrate[direction].write(w, "litmus_max_stable_bitrate_kbps", fmt.Sprintf("direction=%q", direction))
+	}
+
+	fmt.Fprintln(w, "# HELP litmus_test_duration_seconds Duration of completed tests, from offer to result.")
+	fmt.Fprintln(w, "# TYPE litmus_test_duration_seconds histogram")
+	m.duration.write(w, "litmus_test_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP litmus_egress_bytes_total Test packet bytes sent to clients.")
+	fmt.Fprintln(w, "# TYPE litmus_egress_bytes_total counter")
+	fmt.Fprintf(w, "litmus_egress_bytes_total %d\n", m.egressBytes.Load())
+
+	if egressBudget > 0 {
+		fmt.Fprintln(w, "# HELP litmus_egress_reserved_kbps Egress reserved by running download tests.")
+		fmt.Fprintln(w, "# TYPE litmus_egress_reserved_kbps gauge")
+		fmt.Fprintf(w, "litmus_egress_reserved_kbps %d\n", reservedEgress)
+
+		fmt.Fprintln(w, "# HELP litmus_egress_budget_kbps Egress budget of concurrent download tests.")
+		fmt.Fprintln(w, "# TYPE litmus_egress_budget_kbps gauge")
+		fmt.Fprintf(w, "litmus_egress_budget_kbps %d\n", egressBudget)
+
+		fmt.Fprintln(w, "# HELP litmus_waiting_sessions Offers waiting for egress budget.")
+		fmt.Fprintln(w, "# TYPE litmus_waiting_sessions gauge")
+		fmt.Fprintf(w, "litmus_waiting_sessions %d\n", waitingSessions)
+	}
+
+	fmt.Fprintln(w, "# HELP litmus_tuner_steps_total Bitrate changes decided by the tuners, by direction and step.")
+	fmt.Fprintln(w, "# TYPE litmus_tuner_steps_total counter")
+	for _, direction := range []Direction{DirectionDownload, DirectionUpload} {
+		fmt.Fprintf(w, "litmus_tuner_steps_total{direction=%q,step=\"up\"} %d\n", direction, m.steps[tunerStep{direction, true}])
+		fmt.Fprintf(w, "litmus_tuner_steps_total{direction=%q,step=\"down\"} %d\n", direction, m.steps[tunerStep{direction, false}])
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text exposition format
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	active := 0
+	s.connections.Range(func(key, value interface{}) bool {
+		active++
+		return true
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	reserved, budget, waiting := s.admission.usage()
+	s.metrics.write(w, active, reserved, budget, waiting)
+}
+
+// finishTest records how the test of sess ended, only the first outcome counts.
+// An empty reason means the test completed with result. Failures are emitted as test_failed events,
+// completions are left to the caller, which holds the report. Returns false if an outcome was already recorded.
+func (s *Server) finishTest(sess *session, result TestResult, reason string) bool {
+	sess.mu.Lock()
+	counted := !sess.offered || sess.finished
+	sess.finished = true
+	started := sess.testStarted
+	sess.mu.Unlock()
+
+	if counted {
+		return false
+	}
+	if reason == "" {
+		s.metrics.sessionCompleted(result, time.Since(started))
+		return true
+	}
+
+	s.metrics.sessionFailed(reason)
+	failure := Event{
+		Type:   EventTestFailed,
+		Reason: reason,
+	}
+	if result.Download != nil || result.Upload != nil {
+		failure.Result = &result
+	}
+	s.emit(sess, failure)
+	return true
+}
@@ -0,0 +1,286 @@
+package litmus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// MaxMessageSize is the largest signaling message accepted from a client, in bytes
+const MaxMessageSize = 64 * 1024
+
+// Protocol versions. Version 1 is the original protocol without a hello handshake,
+// clients that start with an offer are assumed to speak it.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+	legacyVersion      = 1
+)
+
+// Optional protocol features, negotiated in the hello handshake
+const (
+	FeatureUpload     = "upload"     // upload and both test directions
+	FeatureRTT        = "rtt"        // RTT probes on the rtt data channel, echoed by the client
+	FeatureParameters = "parameters" // test parameters in the offer
+	FeatureQueue      = "queue"      // offers wait in the waiting room instead of being rejected at capacity
+)
+
+// Signaling message types
+const (
+	MessageHello         = "hello"
+	MessageOffer         = "offer"
+	MessageAnswer        = "answer"
+	MessageCandidate     = "candidate"
+	MessageMetricsReport = "metrics_report"
+	MessageBitrateUpdate = "bitrate_update"
+	MessageTestComplete  = "test_complete"
+	MessageQueuePosition = "queue_position"
+	MessageError         = "error"
+)
+
+// Error codes carried by ErrorMessage
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodeMessageTooLarge    = "message_too_large"
+	CodeUnknownType        = "unknown_type"
+	CodeInvalidMessage     = "invalid_message"
+	CodeNegotiationFailed  = "negotiation_failed"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnsupportedFeature = "unsupported_feature"
+	CodeShuttingDown       = "shutting_down" // terminal, the server closes the connection
+	CodeTerminated         = "terminated"    // terminal, an administrator ended the session
+	CodeAtCapacity         = "at_capacity"   // terminal, the server cannot fit another test, retry after retry_after
+	CodeRateLimited        = "rate_limited"  // terminal, the client IP used up its daily byte quota, retry after retry_after
+	CodeInternal           = "internal"
+)
+
+// HelloMessage exchanges protocol version and features, sent first by the client and answered by the server.
+// The server answers with the version both sides will speak and the features both support.
+type HelloMessage struct {
+	Type     string   `json:"type"`
+	Version  int      `json:"version"`
+	Features []string `json:"features"`
+}
+
+// OfferMessage starts a test, sent by the client
+type OfferMessage struct {
+	Type       string          `json:"type"`
+	SDP        string          `json:"sdp"`
+	Direction  Direction       `json:"direction,omitempty"`
+	Parameters *TestParameters `json:"parameters,omitempty"`
+}
+
+// AnswerMessage answers an OfferMessage, sent by the server
+type AnswerMessage struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// CandidateMessage trickles an ICE candidate, sent by both sides
+type CandidateMessage struct {
+	Type      string                  `json:"type"`
+	Candidate webrtc.ICECandidateInit `json:"candidate"`
+}
+
+// MetricsReportMessage carries the client's measurements of the download stream
+type MetricsReportMessage struct {
+	Type             string  `json:"type"`
+	LossRate         float64 `json:"loss_rate"`
+	Jitter           float64 `json:"jitter"`            // milliseconds
+	ActualThroughput float64 `json:"actual_throughput"` // bits/second
+	Sequence         uint32  `json:"sequence,omitempty"`
+}
+
+// BitrateUpdateMessage tells the client the bitrate currently under test, sent by the server
+type BitrateUpdateMessage struct {
+	Type      string    `json:"type"`
+	Direction Direction `json:"direction"`
+	Bitrate   int       `json:"bitrate"` // kbps
+	Final     bool      `json:"final"`   // the phase in this direction is over
+}
+
+// QueuePositionMessage tells a client whose offer waits for capacity where it stands, sent by the server
+// whenever the position changes. The answer follows once the test is admitted, later positions are stale.
+type QueuePositionMessage struct {
+	Type     string `json:"type"`
+	Position int    `json:"position"` // 1 for the next session to be admitted
+	Waiting  int    `json:"waiting"`  // sessions in the waiting room
+}
+
+// TestReport is the outcome of a test as reported to the client
+type TestReport struct {
+	Bitrate     int                 `json:"bitrate"` // kbps, usable in both tested directions
+	IdleRTT     RTTStats            `json:"idle_rtt"`
+	LoadedRTT   RTTStats            `json:"loaded_rtt"`
+	Bufferbloat Bufferbloat         `json:"bufferbloat"`
+	Download    *NetworkCapability  `json:"download"`
+	Upload      *NetworkCapability  `json:"upload"`
+	Profile     string              `json:"profile"` // recommended profile, empty if none passed
+	Passed      []string            `json:"passed"`
+	Failed      []ProfileEvaluation `json:"failed"`
+	Timeline    Timeline            `json:"timeline"`        // every measurement interval and the tuner decision it led to
+	Token       string              `json:"token,omitempty"` // signed ResultClaims, only if the server has a token key
+}
+
+// TestCompleteMessage ends a test, sent by the server
+type TestCompleteMessage struct {
+	Type  string `json:"type"`
+	Final bool   `json:"final"`
+	TestReport
+}
+
+// ErrorMessage reports a problem with a client message, sent by the server
+type ErrorMessage struct {
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds, when the client may try again
+}
+
+// ProtocolError is a signaling error that is reported back to the client
+type ProtocolError struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration // 0 if retrying is not suggested
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// ErrorMessage returns the message that reports e to the client
+func (e *ProtocolError) ErrorMessage() ErrorMessage {
+	return ErrorMessage{
+		Type:       MessageError,
+		Code:       e.Code,
+		Message:    e.Message,
+		RetryAfter: int(math.Ceil(e.RetryAfter.Seconds())),
+	}
+}
+
+func protocolErrorf(code, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// DecodeClientMessage strictly decodes a message sent by a client, rejecting fields unknown to this server.
+// Returns one of *HelloMessage, *OfferMessage, *CandidateMessage or *MetricsReportMessage, or a *ProtocolError.
+// Strict decoding suits legacy clients, which do not negotiate a version; hello messages are always decoded
+// leniently, so that newer clients can be downgraded.
+func DecodeClientMessage(data []byte) (interface{}, error) {
+	return decodeClientMessage(data, true)
+}
+
+// DecodeNegotiatedMessage decodes a message like DecodeClientMessage but ignores unknown fields,
+// for sessions that negotiated a version with hello: clients speaking a newer version may add fields
+// this server does not know about, it downgraded them and only relies on the fields it knows.
+func DecodeNegotiatedMessage(data []byte) (interface{}, error) {
+	return decodeClientMessage(data, false)
+}
+
+func decodeClientMessage(data []byte, strict bool) (interface{}, error) {
+	if len(data) > MaxMessageSize {
+		return nil, protocolErrorf(CodeMessageTooLarge, "message exceeds %d bytes", MaxMessageSize)
+	}
+
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, protocolErrorf(CodeInvalidJSON, "%v", err)
+	}
+
+	var msg interface {
+		validate() error
+	}
+	switch envelope.Type {
+	case MessageHello:
+		msg = &HelloMessage{}
+	case MessageOffer:
+		msg = &OfferMessage{}
+	case MessageCandidate:
+		msg = &CandidateMessage{}
+	case MessageMetricsReport:
+		msg = &MetricsReportMessage{}
+	case "":
+		return nil, protocolErrorf(CodeInvalidMessage, "missing message type")
+	default:
+		return nil, protocolErrorf(CodeUnknownType, "unknown message type %q", envelope.Type)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict && envelope.Type != MessageHello {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(msg); err != nil {
+		return nil, protocolErrorf(CodeInvalidMessage, "%s: %v", envelope.Type, err)
+	}
+
+	if err := msg.validate(); err != nil {
+		return nil, protocolErrorf(CodeInvalidMessage, "%s: %v", envelope.Type, err)
+	}
+	return msg, nil
+}
+
+func (m *HelloMessage) validate() error {
+	if m.Version <= 0 {
+		return errors.New("version must be positive")
+	}
+	return nil
+}
+
+func (m *OfferMessage) validate() error {
+	if m.SDP == "" {
+		return errors.New("missing sdp")
+	}
+	direction, ok := ParseDirection(string(m.Direction))
+	if !ok {
+		return fmt.Errorf("unknown direction %q", m.Direction)
+	}
+	m.Direction = direction
+
+	if m.Parameters != nil {
+		return m.Parameters.validate()
+	}
+	return nil
+}
+
+// An empty candidate signals the end of candidates and is accepted
+func (m *CandidateMessage) validate() error {
+	return nil
+}
+
+func (m *MetricsReportMessage) validate() error {
+	for _, v := range []float64{m.LossRate, m.Jitter, m.ActualThroughput} {
+		if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+			return errors.New("metrics must be finite and non-negative")
+		}
+	}
+	if m.LossRate > 1 {
+		return errors.New("loss_rate must not exceed 1")
+	}
+	return nil
+}
+
+// negotiateFeatures returns the features present in both lists, in server order
+func negotiateFeatures(server, client []string) []string {
+	supported := make(map[string]struct{}, len(client))
+	for _, feature := range client {
+		supported[feature] = struct{}{}
+	}
+
+	features := []string{}
+	for _, feature := range server {
+		if _, ok := supported[feature]; ok {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
@@ -0,0 +1,275 @@
+package litmus
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/blitz-frost/log"
+)
+
+// rateLimitSweepInterval is the least time between two passes forgetting clients with no relevant usage left
+const rateLimitSweepInterval = 10 * time.Minute
+
+// ErrQuotaExceeded ends a download phase whose client IP used up its daily byte quota
+var ErrQuotaExceeded = errors.New("daily byte quota exceeded")
+
+// RateLimits caps what a single client IP can use. Zero fields are unlimited.
+type RateLimits struct {
+	Sessions     int   // concurrent sessions
+	TestsPerHour int   // sessions opened over the last hour
+	BytesPerDay  int64 // test bytes sent over the last 24 hours
+}
+
+func (l RateLimits) enabled() bool {
+	return l.Sessions > 0 || l.TestsPerHour > 0 || l.BytesPerDay > 0
+}
+
+// hourlyBytes counts bytes per hour over the last day
+type hourlyBytes struct {
+	hour  int64 // hours since the epoch
+	bytes int64
+}
+
+type clientUsage struct {
+	sessions int
+	tests    []time.Time // opened within the last hour, oldest first
+	bytes    [24]hourlyBytes
+}
+
+// bytesSince sums the bytes counted in the 24 hours up to hour
+func (u *clientUsage) bytesSince(hour int64) int64 {
+	total := int64(0)
+	for _, bucket := range u.bytes {
+		if bucket.hour > hour-24 {
+			total += bucket.bytes
+		}
+	}
+	return total
+}
+
+// idle reports whether the usage no longer restricts anything at now
+func (u *clientUsage) idle(now time.Time) bool {
+	hour := now.Unix() / 3600
+	return u.sessions == 0 &&
+		(len(u.tests) == 0 || now.Sub(u.tests[len(u.tests)-1]) >= time.Hour) &&
+		u.bytesSince(hour) == 0
+}
+
+// rateLimiter tracks the usage of each client IP against RateLimits. A nil rateLimiter allows everything.
+type rateLimiter struct {
+	limits RateLimits
+	clock  Clock
+
+	mu        sync.Mutex
+	clients   map[string]*clientUsage
+	lastSweep time.Time
+}
+
+func newRateLimiter(limits RateLimits, clock Clock) *rateLimiter {
+	if !limits.enabled() {
+		return nil
+	}
+	return &rateLimiter{
+		limits:    limits,
+		clock:     clock,
+		clients:   make(map[string]*clientUsage),
+		lastSweep: clock.Now(),
+	}
+}
+
+// open counts a new session of ip, unless one of the limits is reached,
+// in which case it returns false and how long until the client may try again
+func (l *rateLimiter) open(ip string) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	u := l.usage(ip)
+
+	tests := u.tests[:0]
+	for _, opened := range u.tests {
+		if now.Sub(opened) < time.Hour {
+			tests = append(tests, opened)
+		}
+	}
+	u.tests = tests
+
+	retryAfter := time.Duration(0)
+	if l.limits.Sessions > 0 && u.sessions >= l.limits.Sessions {
+		// sessions end on their own, the test duration is unknown here
+		retryAfter = minRetryAfter
+	}
+	if l.limits.TestsPerHour > 0 && len(u.tests) >= l.limits.TestsPerHour {
+		if wait := u.tests[len(u.tests)-l.limits.TestsPerHour].Add(time.Hour).Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if l.limits.BytesPerDay > 0 && u.bytesSince(now.Unix()/3600) >= l.limits.BytesPerDay {
+		if wait := l.quotaReset(u, now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return retryAfter, false
+	}
+
+	u.sessions++
+	u.tests = append(u.tests, now)
+	return 0, true
+}
+
+// close ends a session opened by open
+func (l *rateLimiter) close(ip string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if u, ok := l.clients[ip]; ok && u.sessions > 0 {
+		u.sessions--
+	}
+}
+
+// consume counts n bytes sent to ip, ErrQuotaExceeded once the daily quota is used up
+func (l *rateLimiter) consume(ip string, n int) error {
+	if l == nil || l.limits.BytesPerDay <= 0 {
+		return nil
+	}
+
+	now := l.clock.Now()
+	hour := now.Unix() / 3600
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := l.usage(ip)
+	bucket := &u.bytes[hour%24]
+	if bucket.hour != hour {
+		*bucket = hourlyBytes{hour: hour}
+	}
+	bucket.bytes += int64(n)
+
+	if u.bytesSince(hour) > l.limits.BytesPerDay {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// quotaRetryAfter returns how long until ip gets below its daily quota again
+func (l *rateLimiter) quotaRetryAfter(ip string) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.quotaReset(l.usage(ip), l.clock.Now())
+}
+
+// quotaReset returns how long until enough of the bytes counted for u age out to get below the daily quota
+func (l *rateLimiter) quotaReset(u *clientUsage, now time.Time) time.Duration {
+	hour := now.Unix() / 3600
+	total := u.bytesSince(hour)
+	for h := hour - 23; h <= hour; h++ {
+		bucket := u.bytes[h%24]
+		if bucket.hour != h {
+			continue
+		}
+		total -= bucket.bytes
+		if total < l.limits.BytesPerDay {
+			return time.Unix((h+24)*3600, 0).Sub(now)
+		}
+	}
+	return time.Unix((hour+24)*3600, 0).Sub(now)
+}
+
+func (l *rateLimiter) usage(ip string) *clientUsage {
+	u, ok := l.clients[ip]
+	if !ok {
+		u = &clientUsage{}
+		l.clients[ip] = u
+	}
+	return u
+}
+
+// sweep forgets clients whose usage no longer matters, at most every rateLimitSweepInterval
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for ip, u := range l.clients {
+		if u.idle(now) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// clientAddr returns the address of the client behind r. Requests from trusted proxies are attributed to the
+// rightmost X-Forwarded-For address that is not a trusted proxy itself; others to their remote address.
+// The port is kept for direct connections only.
+func (s *Server) clientAddr(r *http.Request) string {
+	peer, err := netip.ParseAddr(hostOf(r.RemoteAddr))
+	if err != nil || !s.trusted(peer) {
+		return r.RemoteAddr
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := peer
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		client = addr
+		if !s.trusted(addr) {
+			break
+		}
+	}
+	if client == peer {
+		return r.RemoteAddr
+	}
+	return client.Unmap().String()
+}
+
+func (s *Server) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitClient opens a rate limited session for the client of r, answering 429 itself if a limit is reached.
+// The returned function ends the session.
+func (s *Server) limitClient(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	ip := hostOf(s.clientAddr(r))
+	retryAfter, ok := s.limiter.open(ip)
+	if !ok {
+		s.metrics.sessionRejected(RejectRateLimit)
+		s.log(Warning, "litmus connection rate limited",
+			Entry{"client", ip},
+			Entry{"retryAfter", retryAfter})
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return func() {
+		s.limiter.close(ip)
+	}, true
+}
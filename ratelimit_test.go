@@ -0,0 +1,177 @@
+package litmus
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	. "github.com/blitz-frost/log"
+)
+
+func TestClientAddr(t *testing.T) {
+	s := NewServer(0, WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string // X-Forwarded-For headers
+		want       string
+	}{
+		{"direct", "198.51.100.7:4000", nil, "198.51.100.7:4000"},
+		{"untrusted peer", "198.51.100.7:4000", []string{"203.0.113.5"}, "198.51.100.7:4000"},
+		{"trusted proxy", "10.0.0.1:4000", []string{"203.0.113.5"}, "203.0.113.5"},
+		{"trusted proxy without header", "10.0.0.1:4000", nil, "10.0.0.1:4000"},
+		{"spoofed leftmost address", "10.0.0.1:4000", []string{"192.0.2.99, 203.0.113.5"}, "203.0.113.5"},
+		{"chain of trusted proxies", "10.0.0.1:4000", []string{"192.0.2.99, 203.0.113.5, 10.0.0.2"}, "203.0.113.5"},
+		{"several headers", "10.0.0.1:4000", []string{"192.0.2.99", "203.0.113.5"}, "203.0.113.5"},
+		{"unparsable rightmost address", "10.0.0.1:4000", []string{"203.0.113.5, unknown"}, "10.0.0.1:4000"},
+		{"direct IPv6", "[2001:db8::1]:4000", nil, "[2001:db8::1]:4000"},
+		{"untrusted IPv6 peer", "[2001:db8::1]:4000", []string{"2001:db8::5"}, "[2001:db8::1]:4000"},
+		{"trusted IPv6 proxy", "[fd00::1]:443", []string{"2001:db8::5"}, "2001:db8::5"},
+		{"IPv4 mapped proxy", "[::ffff:10.0.0.1]:443", []string{"::ffff:203.0.113.5"}, "203.0.113.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/litmus", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, forwarded := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", forwarded)
+			}
+			if got := s.clientAddr(r); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// testRateLimiter is the rateLimiter of a server on a manual clock
+func testRateLimiter(limits RateLimits) (*rateLimiter, *ManualClock) {
+	clock := NewManualClock(time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC))
+	s := NewServer(0, WithRateLimits(limits), WithClock(clock), WithLogger(LineLoggerMake(io.Discard, nil)))
+	return s.limiter, clock
+}
+
+func mustOpen(t *testing.T, l *rateLimiter, ip string) {
+	t.Helper()
+	if retryAfter, ok := l.open(ip); !ok {
+		t.Fatalf("%s limited, retry after %s", ip, retryAfter)
+	}
+}
+
+func mustLimit(t *testing.T, l *rateLimiter, ip string, retryAfter time.Duration) {
+	t.Helper()
+	got, ok := l.open(ip)
+	if ok {
+		l.close(ip)
+		t.Fatalf("%s not limited", ip)
+	}
+	if got != retryAfter {
+		t.Fatalf("%s limited, retry after %s, want %s", ip, got, retryAfter)
+	}
+}
+
+func TestRateLimitSessions(t *testing.T) {
+	if NewServer(0).limiter != nil {
+		t.Fatal("rate limiter without limits")
+	}
+
+	l, _ := testRateLimiter(RateLimits{Sessions: 2})
+	mustOpen(t, l, "192.0.2.1")
+	mustOpen(t, l, "192.0.2.1")
+	mustLimit(t, l, "192.0.2.1", minRetryAfter)
+	mustOpen(t, l, "2001:db8::1")
+
+	l.close("192.0.2.1")
+	mustOpen(t, l, "192.0.2.1")
+	mustLimit(t, l, "192.0.2.1", minRetryAfter)
+}
+
+func TestRateLimitTestsPerHour(t *testing.T) {
+	l, clock := testRateLimiter(RateLimits{TestsPerHour: 2})
+	for i := 0; i < 2; i++ {
+		mustOpen(t, l, "192.0.2.1")
+		l.close("192.0.2.1")
+		clock.Advance(20 * time.Minute)
+	}
+
+	// the first test leaves the window an hour after it was opened
+	mustLimit(t, l, "192.0.2.1", 20*time.Minute)
+	mustOpen(t, l, "192.0.2.2")
+	clock.Advance(20*time.Minute - time.Second)
+	mustLimit(t, l, "192.0.2.1", time.Second)
+	clock.Advance(time.Second)
+	mustOpen(t, l, "192.0.2.1")
+	mustLimit(t, l, "192.0.2.1", 20*time.Minute)
+}
+
+func TestRateLimitBytesPerDay(t *testing.T) {
+	l, clock := testRateLimiter(RateLimits{BytesPerDay: 10000})
+	if err := l.consume("192.0.2.1", 6000); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if err := l.consume("192.0.2.1", 5000); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v past the quota, want ErrQuotaExceeded", err)
+	}
+	if err := l.consume("192.0.2.2", 5000); err != nil {
+		t.Errorf("another client: %v", err)
+	}
+
+	// the quota frees up once the bytes of the 12:00 hour are a day old, at 12:00 the next day
+	clock.Set(time.Date(2025, 1, 1, 13, 45, 0, 0, time.UTC))
+	wait := 22*time.Hour + 15*time.Minute
+	mustLimit(t, l, "192.0.2.1", wait)
+	if retryAfter := l.quotaRetryAfter("192.0.2.1"); retryAfter != wait {
+		t.Errorf("quota retry after %s, want %s", retryAfter, wait)
+	}
+
+	clock.Advance(wait - time.Second)
+	mustLimit(t, l, "192.0.2.1", time.Second)
+	clock.Advance(time.Second)
+	mustOpen(t, l, "192.0.2.1")
+	if err := l.consume("192.0.2.1", 5000); err != nil {
+		t.Errorf("after the reset: %v", err)
+	}
+}
+
+func TestLimitClient(t *testing.T) {
+	s := NewServer(0,
+		WithRateLimits(RateLimits{Sessions: 1}),
+		WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+		WithLogger(LineLoggerMake(io.Discard, nil)))
+
+	request := func(remoteAddr, forwarded string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/litmus", nil)
+		r.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return r
+	}
+
+	closeLimit, ok := s.limitClient(httptest.NewRecorder(), request("10.0.0.1:4000", "203.0.113.5"))
+	if !ok {
+		t.Fatal("first session limited")
+	}
+
+	// the client is limited by its own address, whichever proxy or port it comes through
+	w := httptest.NewRecorder()
+	if _, ok := s.limitClient(w, request("203.0.113.5:5000", "")); ok {
+		t.Fatal("second session of the client not limited")
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if _, ok := s.limitClient(httptest.NewRecorder(), request("10.0.0.1:4000", "198.51.100.7")); !ok {
+		t.Error("another client behind the same proxy limited")
+	}
+
+	closeLimit()
+	if _, ok := s.limitClient(httptest.NewRecorder(), request("10.0.0.2:4000", "203.0.113.5")); !ok {
+		t.Error("client still limited after its session closed")
+	}
+}
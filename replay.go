@@ -0,0 +1,181 @@
+package litmus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Trace is a recorded test timeline to replay through a tuner, with the outcomes it is expected to produce
+type Trace struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Timeline    Timeline `json:"timeline"`
+	// Expect maps strategy names to the outcome the replay produced when last checked
+	Expect map[string]ReplayOutcome `json:"expect,omitempty"`
+}
+
+// LoadTrace reads a trace file. A bare timeline, as written by litmus test -timeline, is accepted too.
+func LoadTrace(path string) (*Trace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var trace Trace
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &trace.Timeline)
+	} else {
+		err = json.Unmarshal(data, &trace)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if trace.Name == "" {
+		trace.Name = strings.TrimSuffix(path, ".json")
+	}
+	return &trace, nil
+}
+
+// Save writes the trace to path as indented JSON
+func (t *Trace) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// PhaseOutcome is the result of replaying the samples of one direction
+type PhaseOutcome struct {
+	MaxStableBitrate int        `json:"max_stable_bitrate"` // kbps
+	Complete         bool       `json:"complete"`           // the search ended before the samples ran out
+	Decisions        []Decision `json:"decisions"`
+}
+
+// ReplayOutcome is the result of replaying a trace, by direction
+type ReplayOutcome map[Direction]PhaseOutcome
+
+// ReplayResult is a replayed trace
+type ReplayResult struct {
+	Outcome ReplayOutcome
+	// Timeline holds the recorded measurements with the replayed decisions and bitrates
+	Timeline Timeline
+	// Diverged is the index of the first sample recorded at another bitrate than the replayed tuner was at, -1 if none.
+	// From there on the recorded measurements no longer describe what the replayed tuner asked for.
+	Diverged int
+}
+
+// Replay feeds the measurements of a timeline through fresh tuners created from config, one per direction,
+// on a clock following the recorded sample times. Decisions are replayed, measurements are not: the replay is open loop.
+func Replay(timeline Timeline, config Config) ReplayResult {
+	result := ReplayResult{
+		Outcome:  ReplayOutcome{},
+		Timeline: make(Timeline, 0, len(timeline)),
+		Diverged: -1,
+	}
+	if len(timeline) == 0 {
+		return result
+	}
+
+	// The tuner is created one interval before the first sample, so that the first sample is not skipped
+	clock := NewManualClock(timeline[0].Time.Add(-config.AdaptInterval))
+	config.Clock = clock
+
+	tuners := map[Direction]*NetworkTuner{}
+	for i, sample := range timeline {
+		tuner, ok := tuners[sample.Direction]
+		if !ok {
+			clock.Set(sample.Time.Add(-config.AdaptInterval))
+			tuner = config.newTuner()
+			tuners[sample.Direction] = tuner
+		}
+		clock.Set(sample.Time)
+
+		before := tuner.getCurrentBitrate()
+		if before != sample.Bitrate && result.Diverged < 0 {
+			result.Diverged = i
+		}
+
+		decision := tuner.adjust(Measurement{
+			LossRate:            sample.LossRate,
+			Jitter:              sample.Jitter,
+			ActualThroughput:    sample.ActualThroughput,
+			ServerEffectiveRate: sample.ServerEffectiveRate,
+		})
+
+		replayed := sample
+		replayed.Bitrate = before
+		replayed.Decision = decision
+		replayed.NextBitrate = tuner.getCurrentBitrate()
+		result.Timeline = append(result.Timeline, replayed)
+
+		phase := result.Outcome[sample.Direction]
+		phase.Decisions = append(phase.Decisions, decision)
+		result.Outcome[sample.Direction] = phase
+	}
+
+	for direction, tuner := range tuners {
+		phase := result.Outcome[direction]
+		phase.MaxStableBitrate = tuner.GetCapability().MaxStableBitrate
+		phase.Complete = tuner.IsTestComplete()
+		result.Outcome[direction] = phase
+	}
+	return result
+}
+
+// Diff describes how o differs from expected, empty if they are equal
+func (o ReplayOutcome) Diff(expected ReplayOutcome) []string {
+	directions := map[Direction]struct{}{}
+	for direction := range o {
+		directions[direction] = struct{}{}
+	}
+	for direction := range expected {
+		directions[direction] = struct{}{}
+	}
+	sorted := make([]string, 0, len(directions))
+	for direction := range directions {
+		sorted = append(sorted, string(direction))
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, name := range sorted {
+		direction := Direction(name)
+		got, ok := o[direction]
+		want, expectedOK := expected[direction]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%s: not replayed", direction))
+			continue
+		case !expectedOK:
+			diffs = append(diffs, fmt.Sprintf("%s: no expectation", direction))
+			continue
+		}
+
+		if got.MaxStableBitrate != want.MaxStableBitrate {
+			diffs = append(diffs, fmt.Sprintf("%s: max stable bitrate %d kbps, expected %d kbps", direction, got.MaxStableBitrate, want.MaxStableBitrate))
+		}
+		if got.Complete != want.Complete {
+			diffs = append(diffs, fmt.Sprintf("%s: complete %t, expected %t", direction, got.Complete, want.Complete))
+		}
+		for i := 0; i < len(got.Decisions) || i < len(want.Decisions); i++ {
+			var g, w Decision
+			if i < len(got.Decisions) {
+				g = got.Decisions[i]
+			}
+			if i < len(want.Decisions) {
+				w = want.Decisions[i]
+			}
+			if g != w {
+				diffs = append(diffs, fmt.Sprintf("%s: decision %d is %q, expected %q", direction, i, g, w))
+				break
+			}
+		}
+	}
+	return diffs
+}
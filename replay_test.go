@@ -0,0 +1,48 @@
+package litmus
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// strategies every corpus trace must hold an expectation for
+var corpusStrategies = []string{"linear", "binary", "exponential"}
+
+// TestReplayCorpus replays every trace of testdata/traces and compares the outcomes to their stored expectations.
+// After an intended tuner change, review the differences with litmus replay -check -v and accept them with -update.
+func TestReplayCorpus(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "traces", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no traces in testdata/traces")
+	}
+
+	for _, path := range paths {
+		trace, err := LoadTrace(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range corpusStrategies {
+			t.Run(trace.Name+"/"+name, func(t *testing.T) {
+				expected, ok := trace.Expect[name]
+				if !ok {
+					t.Fatalf("%s has no %s expectation, record one with litmus replay -update -strategy %s", path, name, name)
+				}
+				strategy, ok := ParseStrategy(name)
+				if !ok {
+					t.Fatalf("unknown strategy %q", name)
+				}
+
+				config := DefaultConfig()
+				config.Strategy = strategy
+				result := Replay(trace.Timeline, config)
+				for _, diff := range result.Outcome.Diff(expected) {
+					t.Error(diff)
+				}
+			})
+		}
+	}
+}
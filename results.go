@@ -0,0 +1,181 @@
+package litmus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/blitz-frost/log"
+)
+
+const resultSaveTimeout = 5 * time.Second
+
+var ErrNoResultStore = errors.New("no result store configured")
+
+// ClientInfo describes the client that ran a test
+type ClientInfo struct {
+	RemoteAddr      string   `json:"remote_addr"`
+	UserAgent       string   `json:"user_agent,omitempty"`
+	Origin          string   `json:"origin,omitempty"`
+	ProtocolVersion int      `json:"protocol_version"`
+	Features        []string `json:"features,omitempty"`
+}
+
+// StoredResult is a completed test as persisted by a ResultStore
+type StoredResult struct {
+	ID        string     `json:"id"` // connection ID of the test
+	Started   time.Time  `json:"started"`
+	Finished  time.Time  `json:"finished"`
+	Direction Direction  `json:"direction"`
+	Client    ClientInfo `json:"client"`
+	Report    TestReport `json:"report"` // capabilities, recommended profile and timeline, as sent to the client
+}
+
+// Duration returns how long the test took, from offer to result
+func (r StoredResult) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// ResultQuery selects stored results. Zero fields do not filter.
+type ResultQuery struct {
+	RemoteAddr string    // host of the client, with or without port
+	Since      time.Time // finished at or after
+	Limit      int       // maximum number of results
+}
+
+// matches reports whether r is selected by the filters of q, ignoring Limit
+func (q ResultQuery) matches(r StoredResult) bool {
+	if q.RemoteAddr != "" && hostOf(q.RemoteAddr) != hostOf(r.Client.RemoteAddr) {
+		return false
+	}
+	if !q.Since.IsZero() && r.Finished.Before(q.Since) {
+		return false
+	}
+	return true
+}
+
+// hostOf strips the port from an address, if any
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// ResultStore persists completed tests. Save is called once per completed test, from the test goroutine.
+type ResultStore interface {
+	Save(ctx context.Context, r StoredResult) error
+	// Query returns the matching results, most recent first
+	Query(ctx context.Context, q ResultQuery) ([]StoredResult, error)
+}
+
+// MemoryStore keeps the latest results in a ring buffer
+type MemoryStore struct {
+	mu      sync.Mutex
+	results []StoredResult
+	next    int // index of the next write once the buffer is full
+}
+
+// NewMemoryStore creates a MemoryStore holding up to capacity results
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryStore{
+		results: make([]StoredResult, 0, capacity),
+	}
+}
+
+func (m *MemoryStore) Save(ctx context.Context, r StoredResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.results) < cap(m.results) {
+		m.results = append(m.results, r)
+		return nil
+	}
+	m.results[m.next] = r
+	m.next = (m.next + 1) % len(m.results)
+	return nil
+}
+
+func (m *MemoryStore) Query(ctx context.Context, q ResultQuery) ([]StoredResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := []StoredResult{}
+	for i := range m.results {
+		// walk backwards from the latest write
+		r := m.results[(m.next-1-i+2*len(m.results))%len(m.results)]
+		if !q.matches(r) {
+			continue
+		}
+		results = append(results, r)
+		if q.Limit > 0 && len(results) == q.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// clientInfo describes the client of the session
+func (sess *session) clientInfo() ClientInfo {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	features := make([]string, 0, len(sess.features))
+	for feature := range sess.features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	return ClientInfo{
+		RemoteAddr:      sess.remoteAddr,
+		UserAgent:       sess.userAgent,
+		Origin:          sess.origin,
+		ProtocolVersion: sess.version,
+		Features:        features,
+	}
+}
+
+// storedResult builds the record of a completed test
+func (sess *session) storedResult(report TestReport) StoredResult {
+	client := sess.clientInfo()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	return StoredResult{
+		ID:        sess.connID,
+		Started:   sess.testStarted,
+		Finished:  time.Now(),
+		Direction: sess.direction,
+		Client:    client,
+		Report:    report,
+	}
+}
+
+// saveResult hands a completed test to the configured ResultStore, if any
+func (s *Server) saveResult(sess *session, report TestReport) {
+	if s.config.ResultStore == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resultSaveTimeout)
+	defer cancel()
+
+	if err := s.config.ResultStore.Save(ctx, sess.storedResult(report)); err != nil {
+		s.err(Error, "litmus result not saved", err, Entry{"connID", sess.connID})
+	}
+}
+
+// Results queries the configured ResultStore, ErrNoResultStore if there is none
+func (s *Server) Results(ctx context.Context, q ResultQuery) ([]StoredResult, error) {
+	if s.config.ResultStore == nil {
+		return nil, ErrNoResultStore
+	}
+	return s.config.ResultStore.Query(ctx, q)
+}
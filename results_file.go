@@ -0,0 +1,93 @@
+package litmus
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileStore appends results to a JSON lines file, one StoredResult per line
+type FileStore struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileStore opens or creates the file at path for appending
+func NewFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{
+		path: path,
+		file: file,
+	}, nil
+}
+
+func (f *FileStore) Save(ctx context.Context, r StoredResult) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// a single write keeps lines whole even if another process appends to the same file
+	_, err = f.file.Write(line)
+	return err
+}
+
+// Query scans the whole file, lines that fail to decode are skipped
+func (f *FileStore) Query(ctx context.Context, q ResultQuery) ([]StoredResult, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var results []StoredResult
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var r StoredResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		if !q.matches(r) {
+			continue
+		}
+		results = append(results, r)
+		// only the latest Limit results are kept, the file is in chronological order
+		if q.Limit > 0 && len(results) > q.Limit {
+			results = results[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	if results == nil {
+		results = []StoredResult{}
+	}
+	return results, nil
+}
+
+// Close closes the underlying file
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
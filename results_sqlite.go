@@ -0,0 +1,118 @@
+package litmus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS litmus_results (
+	id          TEXT PRIMARY KEY,
+	started     INTEGER NOT NULL,
+	finished    INTEGER NOT NULL,
+	remote_host TEXT NOT NULL,
+	direction   TEXT NOT NULL,
+	bitrate     INTEGER NOT NULL,
+	profile     TEXT NOT NULL,
+	result      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS litmus_results_finished ON litmus_results (finished);
+CREATE INDEX IF NOT EXISTS litmus_results_remote_host ON litmus_results (remote_host, finished);
+`
+
+// SQLiteStore keeps results in an SQLite database.
+// Indexed columns hold what queries filter on, the full StoredResult is kept as JSON in the result column.
+//
+// The database is opened by the caller, which picks the driver, for example:
+//
+//	import _ "modernc.org/sqlite"
+//
+//	db, err := sql.Open("sqlite", "results.db")
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore creates the litmus_results table in db if needed
+func NewSQLiteStore(db *sql.DB) (*SQLiteStore, error) {
+	for _, statement := range strings.Split(sqliteSchema, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Save(ctx context.Context, r StoredResult) error {
+	result, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO litmus_results (id, started, finished, remote_host, direction, bitrate, profile, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID,
+		r.Started.UnixNano(),
+		r.Finished.UnixNano(),
+		hostOf(r.Client.RemoteAddr),
+		string(r.Direction),
+		r.Report.Bitrate,
+		r.Report.Profile,
+		string(result),
+	)
+	return err
+}
+
+func (s *SQLiteStore) Query(ctx context.Context, q ResultQuery) ([]StoredResult, error) {
+	query := "SELECT result FROM litmus_results"
+	var where []string
+	var args []interface{}
+	if q.RemoteAddr != "" {
+		where = append(where, "remote_host = ?")
+		args = append(args, hostOf(q.RemoteAddr))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "finished >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY finished DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []StoredResult{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var r StoredResult
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Prune deletes results that finished before the given time
+func (s *SQLiteStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM litmus_results WHERE finished < ?", before.UnixNano())
+	return err
+}
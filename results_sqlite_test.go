@@ -0,0 +1,130 @@
+//go:build sqlite
+
+// Run with go test -tags sqlite, against the pure Go modernc.org/sqlite driver
+
+package litmus
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func openSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the schema is created only if needed
+	if _, err := NewSQLiteStore(db); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func storedResult(id, remoteAddr string, finished time.Time, bitrate int) StoredResult {
+	return StoredResult{
+		ID:        id,
+		Started:   finished.Add(-10 * time.Second),
+		Finished:  finished,
+		Direction: DirectionBoth,
+		Client: ClientInfo{
+			RemoteAddr:      remoteAddr,
+			UserAgent:       "litmus-test",
+			ProtocolVersion: ProtocolVersion,
+			Features:        []string{FeatureUpload},
+		},
+		Report: TestReport{
+			Bitrate:  bitrate,
+			Download: &NetworkCapability{MaxStableBitrate: bitrate},
+			Profile:  "720p",
+			Timeline: Timeline{{Direction: DirectionDownload, Bitrate: bitrate, Decision: DecisionHold, NextBitrate: bitrate}},
+		},
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	store := openSQLiteStore(t)
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	saved := []StoredResult{
+		storedResult("a", "192.0.2.1:5000", base, 3000),
+		storedResult("b", "192.0.2.2:5000", base.Add(time.Minute), 5000),
+		storedResult("c", "192.0.2.1:6000", base.Add(2*time.Minute), 7000),
+	}
+	for _, r := range saved {
+		if err := store.Save(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Save(ctx, saved[0]); err == nil {
+		t.Error("saving a duplicate ID succeeded")
+	}
+
+	tests := []struct {
+		name  string
+		query ResultQuery
+		want  []string // IDs, most recent first
+	}{
+		{"all", ResultQuery{}, []string{"c", "b", "a"}},
+		{"host ignores the port", ResultQuery{RemoteAddr: "192.0.2.1:1"}, []string{"c", "a"}},
+		{"bare host", ResultQuery{RemoteAddr: "192.0.2.2"}, []string{"b"}},
+		{"since is inclusive", ResultQuery{Since: base.Add(time.Minute)}, []string{"c", "b"}},
+		{"limit", ResultQuery{Limit: 1}, []string{"c"}},
+		{"combined", ResultQuery{RemoteAddr: "192.0.2.1", Since: base.Add(time.Second), Limit: 5}, []string{"c"}},
+		{"no match", ResultQuery{RemoteAddr: "198.51.100.1"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.Query(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(results))
+			for i, r := range results {
+				got[i] = r.ID
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	results, err := store.Query(ctx, ResultQuery{RemoteAddr: "192.0.2.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if !r.Finished.Equal(saved[1].Finished) || r.Client.UserAgent != "litmus-test" || r.Report.Download.MaxStableBitrate != 5000 ||
+		len(r.Report.Timeline) != 1 || r.Duration() != 10*time.Second {
+		t.Errorf("result not stored as saved: %+v", r)
+	}
+
+	if err := store.Prune(ctx, base.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	results, err = store.Query(ctx, ResultQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].ID != "b" {
+		t.Errorf("after prune: %d results, want c and b", len(results))
+	}
+}
@@ -0,0 +1,202 @@
+package litmus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	rttChannelLabel   = "rtt"
+	rttProbeInterval  = 100 * time.Millisecond
+	idleProbeDuration = time.Second
+	rttOpenTimeout    = 5 * time.Second
+)
+
+var ErrRTTChannelTimeout = errors.New("rtt data channel did not open")
+
+// RTTStats summarizes round trip time samples, in milliseconds
+type RTTStats struct {
+	Min     float64 `json:"min_ms"`
+	Avg     float64 `json:"avg_ms"`
+	P95     float64 `json:"p95_ms"`
+	Samples int     `json:"samples"`
+}
+
+// NewRTTStats computes the summary of a set of samples given in milliseconds
+func NewRTTStats(samples []float64) RTTStats {
+	if len(samples) == 0 {
+		return RTTStats{}
+	}
+
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, sample := range sorted {
+		sum += sample
+	}
+
+	p95 := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	return RTTStats{
+		Min:     sorted[0],
+		Avg:     sum / float64(len(sorted)),
+		P95:     sorted[p95],
+		Samples: len(sorted),
+	}
+}
+
+// worse returns whichever stats show the higher tail latency
+func (r RTTStats) worse(o RTTStats) RTTStats {
+	if o.Samples > 0 && (r.Samples == 0 || o.P95 > r.P95) {
+		return o
+	}
+	return r
+}
+
+type rttSample struct {
+	rtt     float64 // milliseconds
+	bitrate int     // test bitrate in kbps when the probe returned, 0 if idle
+}
+
+// rttProber sends timestamped probes over a dedicated data channel, which the client echoes back.
+// Samples are collected until the next call to collect.
+type rttProber struct {
+	dc       *webrtc.DataChannel
+	out      dataSender
+	interval time.Duration
+
+	mu       sync.Mutex
+	samples  []rttSample
+	sequence uint32
+	load     func() int // current test bitrate, nil while idle
+}
+
+// newRTTProber opens the probe channel on an established peer connection
+func newRTTProber(ctx context.Context, pc *webrtc.PeerConnection, interval time.Duration, emulator *emulator) (*rttProber, error) {
+	ordered := false
+	maxRetransmits := uint16(0)
+	dc, err := pc.CreateDataChannel(rttChannelLabel, &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make(chan struct{})
+	dc.OnOpen(func() {
+		close(opened)
+	})
+
+	timeout := time.NewTimer(rttOpenTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-opened:
+	case <-ctx.Done():
+		dc.Close()
+		return nil, ctx.Err()
+	case <-timeout.C:
+		dc.Close()
+		return nil, ErrRTTChannelTimeout
+	}
+
+	p := &rttProber{
+		dc:       dc,
+		out:      emulator.sender(dc),
+		interval: interval,
+	}
+	dc.OnMessage(emulator.receiver(func(msg webrtc.DataChannelMessage) {
+		p.observe(msg.Data, time.Now())
+	}))
+	return p, nil
+}
+
+func (p *rttProber) observe(packet []byte, at time.Time) {
+	if len(packet) < headerSize {
+		return
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(packet[headerSize-8:headerSize])))
+	rtt := float64(at.Sub(sent).Microseconds()) / 1000
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sample := rttSample{rtt: rtt}
+	if p.load != nil {
+		sample.bitrate = p.load()
+	}
+	p.samples = append(p.samples, sample)
+}
+
+// setLoad tags subsequent samples with the bitrate returned by f
+func (p *rttProber) setLoad(f func() int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.load = f
+}
+
+// run sends probes until ctx is done
+func (p *rttProber) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		sequence := p.sequence
+		p.sequence++
+		p.mu.Unlock()
+
+		packet, err := NewTestPacket(headerSize, sequence, time.Now())
+		if err != nil {
+			return
+		}
+		if err := p.out.Send(packet); err != nil {
+			return
+		}
+	}
+}
+
+// collect returns the stats of the samples gathered since the previous call
+func (p *rttProber) collect() RTTStats {
+	return p.collectSaturated(0)
+}
+
+// collectSaturated returns the stats of the samples gathered since the previous call while the test bitrate was at least
+// minBitrate, that is while the link was saturated. Falls back to all samples if none qualify.
+func (p *rttProber) collectSaturated(minBitrate int) RTTStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var all, saturated []float64
+	for _, sample := range p.samples {
+		all = append(all, sample.rtt)
+		if sample.bitrate >= minBitrate {
+			saturated = append(saturated, sample.rtt)
+		}
+	}
+	p.samples = nil
+
+	if len(saturated) == 0 {
+		return NewRTTStats(all)
+	}
+	return NewRTTStats(saturated)
+}
+
+func (p *rttProber) close() {
+	p.dc.Close()
+}
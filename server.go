@@ -2,37 +2,111 @@ package litmus
 
 import (
 	"net/http"
-	"strconv"
 	"sync"
 
 	. "github.com/blitz-frost/log"
+	"github.com/gorilla/websocket"
 )
 
 type Server struct {
 	port        uint
 	pathBase    string
-	connections sync.Map
+	config      Config
+	upgrader    websocket.Upgrader
+	connections sync.Map // connID to *session
+	metrics     *serverMetrics
+	admission   *admission
+	limiter     *rateLimiter
+
+	lifecycleMu sync.Mutex
+	httpServer  *http.Server
+	draining    bool
+	active      int
+	drained     chan struct{} // closed when the last session ends while draining
+
+	eventsMu      sync.RWMutex
+	eventHandlers []func(Event)
 }
 
-func NewServer(port uint) *Server {
+// NewServer creates a Server listening on port, starting from DefaultConfig and applying opts in order.
+func NewServer(port uint, opts ...Option) *Server {
+	config := DefaultConfig()
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	checkOrigin := config.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(r *http.Request) bool {
+			return true
+		}
+	}
+
 	return &Server{
-		port: port,
+		port:      port,
+		config:    config,
+		metrics:   newServerMetrics(),
+		admission: newAdmission(config.EgressBudget, config.QueueSize),
+		limiter:   newRateLimiter(config.RateLimits, config.clock()),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: checkOrigin,
+		},
 	}
 }
 
-// RegisterHandlers registers litmus-specific HTTP handlers to the provided ServeMux.
-func (s *Server) RegisterHandlers(mux *http.ServeMux, pathBase string) {
+// Config returns the configuration the server was created with.
+func (s *Server) Config() Config {
+	return s.config
+}
+
+func (s *Server) logger() Logger {
+	if s.config.Logger != nil {
+		return s.config.Logger
+	}
+	return DefaultLogger
+}
+
+func (s *Server) log(lvl int, msg string, e ...EntriesGiver) {
+	s.logger().Log(lvl, msg, e...)
+}
+
+func (s *Server) err(lvl int, msg string, err error, e ...EntriesGiver) {
+	LogError(s.logger(), lvl, msg, err, e...)
+}
+
+// litmusPath returns the path of the litmus endpoint under pathBase
+func litmusPath(pathBase string) string {
 	path := "/litmus"
 	if pathBase != "" {
 		path = "/" + pathBase + path
 	}
+	return path
+}
+
+// RegisterHandlers registers litmus-specific HTTP handlers to the provided ServeMux.
+func (s *Server) RegisterHandlers(mux *http.ServeMux, pathBase string) {
+	path := litmusPath(pathBase)
 
 	handle := func(w http.ResponseWriter, r *http.Request) {
-		Log(Info, "litmus connection attempt")
+		if !s.authenticate(w, r) {
+			return
+		}
+		closeLimit, ok := s.limitClient(w, r)
+		if !ok {
+			return
+		}
+		defer closeLimit()
+		if !s.beginSession() {
+			http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer s.endSession()
+
+		s.log(Info, "litmus connection attempt")
 		var err error
 		defer func() {
 			if err != nil {
-				Err(Error, "litmus connection failed", err)
+				s.err(Error, "litmus connection failed", err)
 			}
 		}()
 
@@ -41,6 +115,10 @@ func (s *Server) RegisterHandlers(mux *http.ServeMux, pathBase string) {
 
 	healthPath := path + "/health"
 	healthHandle := func(w http.ResponseWriter, r *http.Request) {
+		if s.isDraining() {
+			http.Error(w, "Litmus shutting down", http.StatusServiceUnavailable)
+			return
+		}
 		w.Write([]byte("Litmus OK"))
 	}
 
@@ -50,9 +128,12 @@ func (s *Server) RegisterHandlers(mux *http.ServeMux, pathBase string) {
 
 
 // Optionally, retain the Listen function for standalone usage
+// Blocks until the server fails or Shutdown is called, in which case http.ErrServerClosed is returned.
 func (s *Server) ListenStandalone(pathBase string) error {
-	addr := ":" + strconv.FormatUint(uint64(s.port), 10)
-	mux := http.NewServeMux()
-	s.RegisterHandlers(mux, pathBase)
-	return http.ListenAndServe(addr, mux)
+	return s.newHTTPServer(pathBase).ListenAndServe()
+}
+
+// ListenStandaloneTLS is the HTTPS counterpart of ListenStandalone
+func (s *Server) ListenStandaloneTLS(pathBase, certFile, keyFile string) error {
+	return s.newHTTPServer(pathBase).ListenAndServeTLS(certFile, keyFile)
 }
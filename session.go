@@ -0,0 +1,389 @@
+package litmus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/blitz-frost/log"
+	"github.com/pion/webrtc/v3"
+)
+
+// Direction selects which way test packets flow
+type Direction string
+
+const (
+	DirectionDownload Direction = "download" // server to client
+	DirectionUpload   Direction = "upload"   // client to server
+	DirectionBoth     Direction = "both"     // download followed by upload
+)
+
+// ParseDirection converts a client supplied string to a Direction, defaulting to download
+func ParseDirection(s string) (Direction, bool) {
+	switch Direction(s) {
+	case "", DirectionDownload:
+		return DirectionDownload, true
+	case DirectionUpload:
+		return DirectionUpload, true
+	case DirectionBoth:
+		return DirectionBoth, true
+	default:
+		return DirectionDownload, false
+	}
+}
+
+func (d Direction) includesDownload() bool {
+	return d == DirectionDownload || d == DirectionBoth
+}
+
+func (d Direction) includesUpload() bool {
+	return d == DirectionUpload || d == DirectionBoth
+}
+
+// TestResult holds the capabilities measured in each direction
+type TestResult struct {
+	Download *NetworkCapability // nil if not tested
+	Upload   *NetworkCapability // nil if not tested
+}
+
+// Capability returns the capability usable for a two way call, the worse of both directions
+func (r TestResult) Capability() NetworkCapability {
+	switch {
+	case r.Download == nil && r.Upload == nil:
+		return NetworkCapability{}
+	case r.Upload == nil:
+		return *r.Download
+	case r.Download == nil:
+		return *r.Upload
+	}
+
+	c := *r.Download
+	if r.Upload.MaxStableBitrate < c.MaxStableBitrate {
+		c.MaxStableBitrate = r.Upload.MaxStableBitrate
+	}
+	if r.Upload.PacketLossRate > c.PacketLossRate {
+		c.PacketLossRate = r.Upload.PacketLossRate
+	}
+	if r.Upload.Jitter > c.Jitter {
+		c.Jitter = r.Upload.Jitter
+	}
+	c.IdleRTT = c.IdleRTT.worse(r.Upload.IdleRTT)
+	c.LoadedRTT = c.LoadedRTT.worse(r.Upload.LoadedRTT)
+	c.Bufferbloat = c.Bufferbloat.worse(r.Upload.Bufferbloat)
+	return c
+}
+
+// session holds the state of a single test connection
+type session struct {
+	connID         string
+	peerConnection *webrtc.PeerConnection
+	writeJSON      func(v interface{}) error
+	closeSignaling func() error
+	remoteAddr     string
+	userAgent      string
+	origin         string
+	started        time.Time
+	priority       int // waiting room class
+
+	mu            sync.Mutex
+	config        Config // effective configuration of this test, set by the offer
+	direction     Direction
+	downloadTuner *NetworkTuner
+	uploadTuner   *NetworkTuner
+	phase         Direction // direction currently under test, empty outside of the test phases
+	negotiated    bool      // hello handshake done
+	offered       bool      // offer received
+	testStarted   time.Time
+	finished      bool // outcome recorded in the server metrics
+	timeline      Timeline
+	version       int
+	features      map[string]bool
+}
+
+// configure sets up the test requested by the offer.
+// A session runs a single test: if an offer was already configured, it returns false and changes nothing.
+func (sess *session) configure(config Config, direction Direction) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.offered {
+		return false
+	}
+	sess.offered = true
+	sess.testStarted = time.Now()
+	sess.config = config
+	sess.direction = direction
+	sess.downloadTuner = config.newTuner()
+	sess.uploadTuner = config.newTuner()
+	return true
+}
+
+// decode decodes a client message, strictly unless the session negotiated a protocol version
+func (sess *session) decode(data []byte) (interface{}, error) {
+	sess.mu.Lock()
+	negotiated := sess.negotiated
+	sess.mu.Unlock()
+
+	if negotiated {
+		return DecodeNegotiatedMessage(data)
+	}
+	return DecodeClientMessage(data)
+}
+
+// hasOffered reports whether an offer was configured
+func (sess *session) hasOffered() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.offered
+}
+
+// plan returns what configure set up
+func (sess *session) plan() (Config, Direction) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.config, sess.direction
+}
+
+// tuner returns the tuner of a direction, nil before the offer
+func (sess *session) tuner(direction Direction) *NetworkTuner {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if direction == DirectionUpload {
+		return sess.uploadTuner
+	}
+	return sess.downloadTuner
+}
+
+// record appends a measurement interval to the session timeline, unless it already holds as many samples as
+// the longest test can produce
+func (sess *session) record(sample Sample) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if len(sess.timeline) >= sess.config.maxTimelineSamples() {
+		return
+	}
+	sess.timeline = append(sess.timeline, sample)
+}
+
+// timelineSnapshot returns a copy of the timeline recorded so far
+func (sess *session) timelineSnapshot() Timeline {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	timeline := make(Timeline, len(sess.timeline))
+	copy(timeline, sess.timeline)
+	return timeline
+}
+
+// setPhase records the direction currently under test
+func (sess *session) setPhase(direction Direction) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.phase = direction
+}
+
+// setProtocol records the outcome of the hello handshake
+func (sess *session) setProtocol(version int, features []string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.negotiated = true
+	sess.version = version
+	sess.features = make(map[string]bool, len(features))
+	for _, feature := range features {
+		sess.features[feature] = true
+	}
+}
+
+func (sess *session) hasFeature(feature string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.features[feature]
+}
+
+// terminate reports a final error to the client and closes the session
+func (sess *session) terminate(err *ProtocolError) {
+	sess.writeJSON(err.ErrorMessage())
+	sess.peerConnection.Close()
+	sess.closeSignaling()
+}
+
+// runTest runs the requested test phases over dc, then reports the result to the client
+func (s *Server) runTest(ctx context.Context, sess *session, dc *webrtc.DataChannel, testDone chan struct{}, testError chan error) {
+	defer func() {
+		dc.Close()
+		close(testDone)
+		sess.peerConnection.Close()
+	}()
+
+	fail := func(err error) {
+		select {
+		case testError <- err:
+		default:
+		}
+	}
+
+	config, direction := sess.plan()
+	downloadTuner := sess.tuner(DirectionDownload)
+	uploadTuner := sess.tuner(DirectionUpload)
+	var result TestResult
+
+	emulator := newEmulator(s.config.Impairment)
+	defer emulator.close()
+	if emulator != nil {
+		s.log(Info, "Emulating impaired link",
+			Entry{"impairment", s.config.Impairment.String()},
+			Entry{"connID", sess.connID})
+	}
+
+	var prober *rttProber
+	var idleRTT RTTStats
+	if sess.hasFeature(FeatureRTT) {
+		prober, idleRTT = s.probeIdleRTT(ctx, sess, config, emulator)
+	}
+	if prober != nil {
+		probeCtx, stopProbing := context.WithCancel(ctx)
+		defer stopProbing()
+		defer prober.close()
+		go prober.run(probeCtx)
+	}
+	startLoad := func(tuner *NetworkTuner) {
+		if prober != nil {
+			prober.setLoad(tuner.getCurrentBitrate)
+		}
+	}
+	// loaded RTT only counts probes taken while the link was saturated, at or above the final stable bitrate
+	measureLoad := func(capability *NetworkCapability) {
+		capability.IdleRTT = idleRTT
+		if prober != nil {
+			capability.LoadedRTT = prober.collectSaturated(capability.MaxStableBitrate)
+		}
+		capability.Bufferbloat = GradeBufferbloat(capability.IdleRTT, capability.LoadedRTT)
+	}
+
+	defer sess.setPhase("")
+
+	if direction.includesDownload() {
+		sess.setPhase(DirectionDownload)
+		startLoad(downloadTuner)
+		sender := emulator.sender(dc)
+		if err := s.stream(ctx, sender, sess, downloadTuner, config.MaxTestDuration); err != nil {
+			if errors.Is(err, ErrQuotaExceeded) {
+				if s.finishTest(sess, result, FailureRateLimited) {
+					rejection := protocolErrorf(CodeRateLimited, "daily byte quota exceeded")
+					rejection.RetryAfter = s.limiter.quotaRetryAfter(hostOf(sess.remoteAddr))
+					sess.terminate(rejection)
+				}
+				fail(err)
+				return
+			}
+			s.finishTest(sess, result, FailureDownload)
+			fail(err)
+			return
+		}
+		s.admission.release(sess.connID)
+		if direction.includesUpload() {
+			s.drainStream(ctx, sender, sess.connID)
+		}
+		capability := downloadTuner.GetCapability()
+		measureLoad(&capability)
+		result.Download = &capability
+	}
+
+	if direction.includesUpload() {
+		sess.setPhase(DirectionUpload)
+		startLoad(uploadTuner)
+		if err := s.receive(ctx, dc, sess, uploadTuner, config.MaxTestDuration, emulator); err != nil {
+			s.finishTest(sess, result, FailureUpload)
+			fail(err)
+			return
+		}
+		capability := uploadTuner.GetCapability()
+		measureLoad(&capability)
+		result.Upload = &capability
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	capability := result.Capability()
+	recommendation := RecommendProfile(capability)
+	report := TestReport{
+		Bitrate:     capability.MaxStableBitrate,
+		IdleRTT:     capability.IdleRTT,
+		LoadedRTT:   capability.LoadedRTT,
+		Bufferbloat: capability.Bufferbloat,
+		Download:    result.Download,
+		Upload:      result.Upload,
+		Profile:     recommendation.ProfileName(),
+		Passed:      recommendation.PassedNames(),
+		Failed:      recommendation.Failed,
+		Timeline:    sess.timelineSnapshot(),
+	}
+	token, err := s.resultToken(sess, report)
+	if err != nil {
+		s.err(Error, "litmus result token not signed", err, Entry{"connID", sess.connID})
+	}
+	report.Token = token
+
+	// The outcome is recorded before the report is sent: a client closing as soon as it has the report
+	// would otherwise race the aborted outcome recorded when the connection ends.
+	if !s.finishTest(sess, result, "") {
+		// terminated, cut off by Shutdown or aborted meanwhile, and counted as such
+		return
+	}
+	s.emit(sess, Event{
+		Type:       EventTestCompleted,
+		Result:     &result,
+		Capability: &capability,
+		Report:     &report,
+	})
+	if err := sess.writeJSON(TestCompleteMessage{
+		Type:       MessageTestComplete,
+		Final:      true,
+		TestReport: report,
+	}); err != nil {
+		s.log(Error, "Failed to send test complete message",
+			Entry{"error", err},
+			Entry{"connID", sess.connID})
+		fail(err)
+	}
+	s.saveResult(sess, report)
+}
+
+// probeIdleRTT opens the RTT probe channel and measures round trip time before any test traffic.
+// Returns a nil prober if RTT measurement is disabled or the client does not support it.
+func (s *Server) probeIdleRTT(ctx context.Context, sess *session, config Config, emulator *emulator) (*rttProber, RTTStats) {
+	if config.RTTInterval <= 0 {
+		return nil, RTTStats{}
+	}
+
+	prober, err := newRTTProber(ctx, sess.peerConnection, config.RTTInterval, emulator)
+	if err != nil {
+		s.log(Warning, "RTT probing unavailable",
+			Entry{"error", err},
+			Entry{"connID", sess.connID})
+		return nil, RTTStats{}
+	}
+
+	idleCtx, cancel := context.WithTimeout(ctx, config.IdleRTTDuration)
+	defer cancel()
+	prober.run(idleCtx)
+
+	return prober, prober.collect()
+}
+
+// features returns the optional protocol features this server supports
+func (s *Server) features() []string {
+	features := []string{FeatureUpload, FeatureParameters}
+	if s.config.RTTInterval > 0 {
+		features = append(features, FeatureRTT)
+	}
+	if s.admission != nil && s.admission.queueSize > 0 {
+		features = append(features, FeatureQueue)
+	}
+	return features
+}
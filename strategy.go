@@ -0,0 +1,340 @@
+package litmus
+
+import (
+	"math"
+)
+
+// Measurement is a single interval of metrics fed to a BitrateStrategy
+type Measurement struct {
+	LossRate            float64 // fraction of packets lost, as reported by the receiver
+	Jitter              float64 // milliseconds, as reported by the receiver
+	ActualThroughput    float64 // bits/second received
+	ServerEffectiveRate float64 // bits/second actually sent
+}
+
+// BitrateStrategy decides which bitrate to test next.
+// Implementations hold per session state and are not required to be concurrent safe, the NetworkTuner serializes access.
+type BitrateStrategy interface {
+	Bitrate() int              // bitrate currently under test, in kbps
+	Update(m Measurement) bool // consume one interval, returns false when the search is complete
+	Best() NetworkCapability   // highest stable capability found so far
+}
+
+// StrategyFactory creates a fresh BitrateStrategy for a session
+type StrategyFactory func(c Config) BitrateStrategy
+
+// LinearSearch steps up by StepSize after each stable period and down after each failed one.
+// This is the default strategy.
+func LinearSearch(c Config) BitrateStrategy {
+	return newLinearStrategy(c.InitialBitrate, c.MaxBitrate, c.StepSize, c.MinBitrate, c.Thresholds)
+}
+
+// BinarySearch bisects between MinBitrate and MaxBitrate until the interval is narrower than StepSize
+func BinarySearch(c Config) BitrateStrategy {
+	return newBinaryStrategy(c.MinBitrate, c.MaxBitrate, c.StepSize, c.Thresholds)
+}
+
+// ExponentialSearch doubles the bitrate from InitialBitrate until a probe fails or MaxBitrate is reached,
+// then refines by bisecting between the last stable and the failed bitrate
+func ExponentialSearch(c Config) BitrateStrategy {
+	return newExponentialStrategy(c.InitialBitrate, c.MinBitrate, c.MaxBitrate, c.StepSize, c.Thresholds)
+}
+
+// ParseStrategy returns the built-in strategy with the given name: linear, binary or exponential
+func ParseStrategy(name string) (StrategyFactory, bool) {
+	switch name {
+	case "", "linear":
+		return LinearSearch, true
+	case "binary":
+		return BinarySearch, true
+	case "exponential":
+		return ExponentialSearch, true
+	default:
+		return nil, false
+	}
+}
+
+// effectiveRateDeviation returns how far the server effective rate strays from the target bitrate, in percent
+func effectiveRateDeviation(bitrate int, m Measurement) float64 {
+	targetBitrateInBps := float64(bitrate) * 1000
+
+	// Calculate deviation of server effective rate from target bitrate
+	deviation := 0.0
+	if m.ServerEffectiveRate > 0 {
+		deviation = math.Abs((targetBitrateInBps - m.ServerEffectiveRate) / targetBitrateInBps * 100)
+	}
+	return deviation
+}
+
+// isStable reports whether a measurement taken at bitrate satisfies the thresholds
+func (t Thresholds) isStable(bitrate int, m Measurement) bool {
+	clientToServerEffectiveRatio := 0.0
+	if m.ServerEffectiveRate > 0 {
+		clientToServerEffectiveRatio = (m.ActualThroughput / m.ServerEffectiveRate) * 100
+	}
+
+	return m.LossRate <= t.MaxPacketLossRate &&
+		m.Jitter <= t.MaxJitter &&
+		effectiveRateDeviation(bitrate, m) <= t.MaxEffectiveRateDeviation &&
+		clientToServerEffectiveRatio >= t.MinimumThroughputRatio
+}
+
+type linearStrategy struct {
+	currentBitrate int
+	maxBitrate     int
+	minBitrate     int
+	stepSize       int
+	stableCount    int
+	failureCount   int
+	deviationCount int
+	bestStable     NetworkCapability
+	thresholds     Thresholds
+}
+
+func newLinearStrategy(initialBitrate, maxBitrate, stepSize, minBitrate int, thresholds Thresholds) *linearStrategy {
+	return &linearStrategy{
+		currentBitrate: initialBitrate,
+		maxBitrate:     maxBitrate,
+		minBitrate:     minBitrate,
+		stepSize:       stepSize,
+		thresholds:     thresholds,
+	}
+}
+
+func (ls *linearStrategy) Bitrate() int {
+	return ls.currentBitrate
+}
+
+func (ls *linearStrategy) Best() NetworkCapability {
+	return ls.bestStable
+}
+
+func (ls *linearStrategy) Update(m Measurement) bool {
+	deviation := effectiveRateDeviation(ls.currentBitrate, m)
+
+	if deviation > ls.thresholds.MaxEffectiveRateDeviation {
+		ls.deviationCount++
+
+		// If we see consistent high deviation, step down regardless of other metrics
+		if ls.deviationCount >= ls.thresholds.DeviationIntervals {
+			// Step down bitrate
+			newBitrate := int(m.ServerEffectiveRate / 1000) // Convert to kbps
+
+			if newBitrate < ls.currentBitrate-ls.stepSize {
+				newBitrate = ls.currentBitrate - ls.stepSize
+			}
+			// a sender running ahead of the target deviates too, that is no reason to go up
+			if newBitrate > ls.currentBitrate {
+				newBitrate = ls.currentBitrate
+			}
+
+			ls.currentBitrate = newBitrate
+			ls.deviationCount = 0
+			ls.stableCount = 0
+
+			if ls.currentBitrate < ls.minBitrate {
+				return false
+			}
+
+			ls.bestStable = NetworkCapability{
+				MaxStableBitrate: newBitrate,
+				PacketLossRate:   m.LossRate,
+				Jitter:           m.Jitter,
+			}
+
+			return true
+		}
+	} else {
+		ls.deviationCount = 0
+	}
+
+	// Check if current bitrate is stable
+	if ls.thresholds.isStable(ls.currentBitrate, m) {
+		ls.stableCount++
+		ls.failureCount = 0
+
+		if ls.stableCount >= ls.thresholds.StableIntervals {
+			currentCapability := NetworkCapability{
+				MaxStableBitrate: ls.currentBitrate,
+				PacketLossRate:   m.LossRate,
+				Jitter:           m.Jitter,
+			}
+
+			if ls.bestStable.MaxStableBitrate > 0 {
+				lastThroughput := float64(ls.bestStable.MaxStableBitrate)
+				currentThroughput := float64(currentCapability.MaxStableBitrate)
+
+				if currentThroughput < lastThroughput*1.05 {
+					return false
+				}
+			}
+
+			ls.bestStable = currentCapability
+
+			// Try higher bitrate if not at max and deviation is low
+			if ls.currentBitrate < ls.maxBitrate && deviation < ls.thresholds.StepUpEffectiveDeviation {
+				ls.currentBitrate += ls.stepSize
+				ls.stableCount = 0
+			} else {
+				// We reached maxBitrate or high deviation
+				return false
+			}
+		}
+	} else {
+		ls.failureCount++
+		ls.stableCount = 0
+
+		if ls.failureCount >= ls.thresholds.FailureIntervals {
+			ls.currentBitrate -= ls.stepSize
+			if ls.currentBitrate < ls.minBitrate {
+				return false
+			}
+			ls.failureCount = 0
+		}
+	}
+
+	return true
+}
+
+const (
+	probePending = iota
+	probePassed
+	probeFailed
+)
+
+// probe holds a single bitrate until it is either stable or failing for long enough
+type probe struct {
+	stableCount  int
+	failureCount int
+	capability   NetworkCapability // last stable measurement
+}
+
+func (p *probe) observe(bitrate int, m Measurement, thresholds Thresholds) int {
+	if !thresholds.isStable(bitrate, m) {
+		p.failureCount++
+		p.stableCount = 0
+		if p.failureCount >= thresholds.FailureIntervals {
+			return probeFailed
+		}
+		return probePending
+	}
+
+	p.stableCount++
+	p.failureCount = 0
+	p.capability = NetworkCapability{
+		MaxStableBitrate: bitrate,
+		PacketLossRate:   m.LossRate,
+		Jitter:           m.Jitter,
+	}
+	if p.stableCount >= thresholds.StableIntervals {
+		return probePassed
+	}
+	return probePending
+}
+
+type binaryStrategy struct {
+	low            int // highest bitrate known to be stable, or the floor
+	high           int // lowest bitrate known to fail, or the ceiling
+	stepSize       int
+	currentBitrate int
+	probe          probe
+	bestStable     NetworkCapability
+	thresholds     Thresholds
+}
+
+func newBinaryStrategy(floor, ceiling, stepSize int, thresholds Thresholds) *binaryStrategy {
+	bs := &binaryStrategy{
+		stepSize:   stepSize,
+		thresholds: thresholds,
+	}
+	bs.reset(floor, ceiling)
+	return bs
+}
+
+// reset starts bisecting the [low, high] interval
+func (bs *binaryStrategy) reset(low, high int) {
+	bs.low = low
+	bs.high = high
+	bs.currentBitrate = (low + high) / 2
+	bs.probe = probe{}
+}
+
+func (bs *binaryStrategy) Bitrate() int {
+	return bs.currentBitrate
+}
+
+func (bs *binaryStrategy) Best() NetworkCapability {
+	return bs.bestStable
+}
+
+func (bs *binaryStrategy) Update(m Measurement) bool {
+	switch bs.probe.observe(bs.currentBitrate, m, bs.thresholds) {
+	case probePassed:
+		bs.bestStable = bs.probe.capability
+		bs.low = bs.currentBitrate
+	case probeFailed:
+		bs.high = bs.currentBitrate
+	default:
+		return true
+	}
+
+	if bs.high-bs.low <= bs.stepSize {
+		return false
+	}
+	bs.reset(bs.low, bs.high)
+	return true
+}
+
+type exponentialStrategy struct {
+	binaryStrategy
+	probing    bool // still doubling, refinement has not started
+	minBitrate int
+	maxBitrate int
+}
+
+func newExponentialStrategy(initialBitrate, minBitrate, maxBitrate, stepSize int, thresholds Thresholds) *exponentialStrategy {
+	return &exponentialStrategy{
+		binaryStrategy: binaryStrategy{
+			low:            minBitrate,
+			high:           maxBitrate,
+			stepSize:       stepSize,
+			currentBitrate: initialBitrate,
+			thresholds:     thresholds,
+		},
+		probing:    true,
+		minBitrate: minBitrate,
+		maxBitrate: maxBitrate,
+	}
+}
+
+func (es *exponentialStrategy) Update(m Measurement) bool {
+	if !es.probing {
+		return es.binaryStrategy.Update(m)
+	}
+
+	switch es.probe.observe(es.currentBitrate, m, es.thresholds) {
+	case probePassed:
+		es.bestStable = es.probe.capability
+		es.low = es.currentBitrate
+		if es.currentBitrate >= es.maxBitrate {
+			return false
+		}
+
+		es.currentBitrate *= 2
+		if es.currentBitrate > es.maxBitrate {
+			es.currentBitrate = es.maxBitrate
+		}
+		es.probe = probe{}
+		return true
+	case probeFailed:
+		es.probing = false
+		es.high = es.currentBitrate
+		if es.high-es.low <= es.stepSize {
+			return false
+		}
+		es.reset(es.low, es.high)
+		return true
+	default:
+		return true
+	}
+}
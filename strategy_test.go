@@ -0,0 +1,233 @@
+package litmus
+
+import (
+	"testing"
+	"time"
+)
+
+// strategyTestConfig searches 1000 to 5000 kbps by steps of 1000 with short streaks, so that the tables stay readable
+func strategyTestConfig() Config {
+	config := DefaultConfig()
+	config.InitialBitrate = 2000
+	config.MinBitrate = 1000
+	config.MaxBitrate = 5000
+	config.StepSize = 1000
+	config.Thresholds.StableIntervals = 2
+	config.Thresholds.FailureIntervals = 3
+	config.Thresholds.DeviationIntervals = 2
+	return config
+}
+
+// clean is received exactly as sent, at the target bitrate
+func clean(bitrate int) Measurement {
+	rate := float64(bitrate) * 1000
+	return Measurement{ServerEffectiveRate: rate, ActualThroughput: rate, Jitter: 5}
+}
+
+// lossy is clean but for 5% of the packets
+func lossy(bitrate int) Measurement {
+	m := clean(bitrate)
+	m.LossRate = 0.05
+	m.ActualThroughput *= 0.95
+	return m
+}
+
+// sending is clean but for a sender that manages ratio times the target bitrate
+func sending(ratio float64) func(int) Measurement {
+	return func(bitrate int) Measurement {
+		m := clean(bitrate)
+		m.ServerEffectiveRate *= ratio
+		m.ActualThroughput = m.ServerEffectiveRate
+		return m
+	}
+}
+
+type strategyStep struct {
+	measure func(bitrate int) Measurement // of the bitrate under test
+	done    bool                          // the search is complete after the step
+	bitrate int                           // under test after the step
+}
+
+func TestStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy StrategyFactory
+		steps    []strategyStep
+		best     int
+	}{
+		{
+			name:     "linear/steady",
+			strategy: LinearSearch,
+			steps: []strategyStep{
+				{measure: clean, bitrate: 2000},
+				{measure: clean, bitrate: 3000},
+				{measure: clean, bitrate: 3000},
+				{measure: clean, bitrate: 4000},
+				{measure: clean, bitrate: 4000},
+				{measure: clean, bitrate: 5000},
+				{measure: clean, bitrate: 5000},
+				{measure: clean, done: true, bitrate: 5000},
+			},
+			best: 5000,
+		},
+		{
+			name:     "linear/deviation streak",
+			strategy: LinearSearch,
+			steps: []strategyStep{
+				// a sender running ahead is not followed up
+				{measure: sending(1.5), bitrate: 2000},
+				{measure: sending(1.5), bitrate: 2000},
+				// one falling behind is followed down to its rate
+				{measure: sending(0.6), bitrate: 2000},
+				{measure: sending(0.6), bitrate: 1200},
+				{measure: clean, bitrate: 1200},
+				{measure: clean, done: true, bitrate: 1200},
+			},
+			best: 1200,
+		},
+		{
+			name:     "linear/loss and recovery",
+			strategy: LinearSearch,
+			steps: []strategyStep{
+				{measure: clean, bitrate: 2000},
+				{measure: clean, bitrate: 3000},
+				// a single lossy interval restarts the stable streak
+				{measure: clean, bitrate: 3000},
+				{measure: lossy, bitrate: 3000},
+				{measure: clean, bitrate: 3000},
+				{measure: clean, bitrate: 4000},
+				{measure: lossy, bitrate: 4000},
+				{measure: lossy, bitrate: 4000},
+				{measure: lossy, bitrate: 3000},
+				{measure: clean, bitrate: 3000},
+				{measure: clean, done: true, bitrate: 3000},
+			},
+			best: 3000,
+		},
+		{
+			name:     "binary/steady",
+			strategy: BinarySearch,
+			steps: []strategyStep{
+				{measure: clean, bitrate: 3000},
+				{measure: clean, bitrate: 4000},
+				{measure: clean, bitrate: 4000},
+				{measure: clean, done: true, bitrate: 4000},
+			},
+			best: 4000,
+		},
+		{
+			name:     "binary/deviation streak",
+			strategy: BinarySearch,
+			steps: []strategyStep{
+				{measure: sending(0.6), bitrate: 3000},
+				{measure: sending(0.6), bitrate: 3000},
+				{measure: sending(0.6), bitrate: 2000},
+				{measure: clean, bitrate: 2000},
+				{measure: clean, done: true, bitrate: 2000},
+			},
+			best: 2000,
+		},
+		{
+			name:     "binary/loss and recovery",
+			strategy: BinarySearch,
+			steps: []strategyStep{
+				{measure: clean, bitrate: 3000},
+				{measure: lossy, bitrate: 3000},
+				{measure: clean, bitrate: 3000},
+				{measure: clean, bitrate: 4000},
+				{measure: lossy, bitrate: 4000},
+				{measure: lossy, bitrate: 4000},
+				// the failed probe closes the interval, the stable bitrate below it is the result
+				{measure: lossy, done: true, bitrate: 4000},
+			},
+			best: 3000,
+		},
+		{
+			name:     "exponential/steady",
+			strategy: ExponentialSearch,
+			steps: []strategyStep{
+				{measure: clean, bitrate: 2000},
+				{measure: clean, bitrate: 4000},
+				{measure: clean, bitrate: 4000},
+				{measure: clean, bitrate: 5000},
+				{measure: clean, bitrate: 5000},
+				{measure: clean, done: true, bitrate: 5000},
+			},
+			best: 5000,
+		},
+		{
+			name:     "exponential/deviation streak",
+			strategy: ExponentialSearch,
+			steps: []strategyStep{
+				{measure: clean, bitrate: 2000},
+				{measure: clean, bitrate: 4000},
+				{measure: sending(0.6), bitrate: 4000},
+				{measure: sending(0.6), bitrate: 4000},
+				{measure: sending(0.6), bitrate: 3000},
+				{measure: clean, bitrate: 3000},
+				{measure: clean, done: true, bitrate: 3000},
+			},
+			best: 3000,
+		},
+		{
+			name:     "exponential/loss and recovery",
+			strategy: ExponentialSearch,
+			steps: []strategyStep{
+				{measure: clean, bitrate: 2000},
+				{measure: lossy, bitrate: 2000},
+				{measure: clean, bitrate: 2000},
+				{measure: clean, bitrate: 4000},
+				{measure: lossy, bitrate: 4000},
+				{measure: lossy, bitrate: 4000},
+				{measure: lossy, bitrate: 3000},
+				{measure: clean, bitrate: 3000},
+				{measure: clean, done: true, bitrate: 3000},
+			},
+			best: 3000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := tt.strategy(strategyTestConfig())
+			for i, step := range tt.steps {
+				done := !strategy.Update(step.measure(strategy.Bitrate()))
+				if bitrate := strategy.Bitrate(); done != step.done || bitrate != step.bitrate {
+					t.Fatalf("step %d: done %t at %d kbps, want done %t at %d kbps", i, done, bitrate, step.done, step.bitrate)
+				}
+			}
+			if best := strategy.Best().MaxStableBitrate; best != tt.best {
+				t.Errorf("best %d kbps, want %d", best, tt.best)
+			}
+		})
+	}
+}
+
+// TestTunerAdaptInterval drives the linear search through a NetworkTuner on a manual clock:
+// measurements arriving within the adapt interval of the previous one are skipped
+func TestTunerAdaptInterval(t *testing.T) {
+	config := strategyTestConfig()
+	clock := NewManualClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	config.Clock = clock
+	tuner := config.newTuner()
+
+	steps := []struct {
+		advance  time.Duration
+		decision Decision
+		bitrate  int
+	}{
+		{config.AdaptInterval / 2, DecisionSkipped, 2000},
+		{config.AdaptInterval / 2, DecisionHold, 2000},
+		{config.AdaptInterval - time.Millisecond, DecisionSkipped, 2000},
+		{time.Millisecond, DecisionIncrease, 3000},
+		{0, DecisionSkipped, 3000},
+		{time.Minute, DecisionHold, 3000},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		decision := tuner.adjust(clean(tuner.getCurrentBitrate()))
+		if bitrate := tuner.getCurrentBitrate(); decision != step.decision || bitrate != step.bitrate {
+			t.Fatalf("step %d: %s at %d kbps, want %s at %d kbps", i, decision, bitrate, step.decision, step.bitrate)
+		}
+	}
+}
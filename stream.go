@@ -16,19 +16,43 @@ const (
 	maxTestDuration = 200 * time.Second
 )
 
-func stream(ctx context.Context, dc *webrtc.DataChannel, connID string, testDone chan struct{}, testError chan error, networkTuner *NetworkTuner, peerConnection *webrtc.PeerConnection) {
+// NewTestPacket builds a test packet of the given size.
+// It starts with a big endian sequence number and the send time in nanoseconds, followed by random padding.
+func NewTestPacket(size int, sequence uint32, at time.Time) ([]byte, error) {
+	if size < headerSize {
+		size = headerSize
+	}
+
+	packet := make([]byte, size)
+	binary.BigEndian.PutUint32(packet[0:headerSize-8], sequence)
+	binary.BigEndian.PutUint64(packet[headerSize-8:headerSize], uint64(at.UnixNano()))
+
+	if _, err := rand.Read(packet[headerSize:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+const (
+	streamTick     = 5 * time.Millisecond // pacing resolution of the test stream
+	maxStreamBurst = 32                   // packets sent in a single tick at most
+	maxStreamDrain = 3 * time.Second      // longest wait for the download stream to drain before the upload phase
+
+	maxStreamBacklog = 250 * time.Millisecond // of packets at the current bitrate, queued in the data channel at most
+)
+
+// stream sends test packets to the client at the tuner's current bitrate until the tuner completes.
+// The tuner is driven by the client's metrics reports.
+// Sent bytes count against the daily quota of the client IP, ErrQuotaExceeded is returned once it is used up.
+func (s *Server) stream(ctx context.Context, dc dataSender, sess *session, networkTuner *NetworkTuner, maxDuration time.Duration) error {
+	connID := sess.connID
+	clientIP := hostOf(sess.remoteAddr)
 	startTime := time.Now()
 	sequence := uint32(0)
 
-	ticker := time.NewTicker(time.Millisecond * 100)
+	ticker := time.NewTicker(streamTick)
 	defer ticker.Stop()
 
-	defer func() {
-		dc.Close()
-		close(testDone)
-		peerConnection.Close()
-	}()
-
 	calculatePacketRate := func(bitrate int) (packetSize int, packetsPerSecond int) {
 		packetSize = defaultPacketSize
 		bitsPerPacket := packetSize * 8
@@ -36,6 +60,12 @@ func stream(ctx context.Context, dc *webrtc.DataChannel, connID string, testDone
 		return
 	}
 
+	// packets are paced by the time elapsed at the current bitrate, a tick sends all those that are due,
+	// so that the sender keeps up with bitrates above one packet per tick
+	var pacedBitrate int
+	var pacedSince time.Time
+	var pacedPackets int
+
    var lastBufferedAmount uint64
    var totalBytesSent uint64
    lastCheckTime := time.Now()
@@ -43,49 +73,68 @@ func stream(ctx context.Context, dc *webrtc.DataChannel, connID string, testDone
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		case <-ticker.C:
 			if networkTuner.IsTestComplete() {
-				Log(Info, "Network testing complete", Entry{"connID", connID})
-				return
+				s.log(Info, "Network testing complete", Entry{"connID", connID})
+				return nil
 			}
 
 			currentBitrate := networkTuner.getCurrentBitrate()
 			packetSize, packetsPerSecond := calculatePacketRate(currentBitrate)
 
-			if packetsPerSecond > 0 {
-				newInterval := time.Second / time.Duration(packetsPerSecond)
-				ticker.Reset(newInterval)
+			if currentBitrate != pacedBitrate {
+				pacedBitrate = currentBitrate
+				pacedSince = time.Now()
+				pacedPackets = 0
+			}
+			due := int(time.Since(pacedSince).Seconds()*float64(packetsPerSecond)) + 1 - pacedPackets
+			if due > maxStreamBurst {
+				// the sender stalled, the missed packets are skipped rather than sent at once
+				pacedPackets += due - maxStreamBurst
+				due = maxStreamBurst
+			}
+			backlog := uint64(currentBitrate) * 1000 / 8 * uint64(maxStreamBacklog.Milliseconds()) / 1000
+			if dc.BufferedAmount() > backlog {
+				// the link does not keep up, the packets are skipped rather than queued behind those waiting,
+				// so that the backlog stays short enough to drain before the upload phase
+				pacedPackets += due
+				due = 0
 			}
 
-			packet := make([]byte, packetSize)
-			binary.BigEndian.PutUint32(packet[0:headerSize-8], sequence)
-			binary.BigEndian.PutUint64(packet[headerSize-8:headerSize], uint64(time.Now().UnixNano()))
+			for ; due > 0; due-- {
+				packet, err := NewTestPacket(packetSize, sequence, time.Now())
+				if err != nil {
+					s.log(Error, "Failed to generate random data",
+						Entry{"error", err},
+						Entry{"connID", connID})
+					return err
+				}
 
-			if _, err := rand.Read(packet[headerSize:]); err != nil {
-				Log(Error, "Failed to generate random data",
-					Entry{"error", err},
-					Entry{"connID", connID})
-				testError <- err
-				return
-			}
+				if err := dc.Send(packet); err != nil {
+					s.log(Error, "Failed to send test packet",
+						Entry{"error", err},
+						Entry{"connID", connID})
+					return err
+				}
+				totalBytesSent += uint64(packetSize)
+				s.metrics.egressBytes.Add(uint64(packetSize))
+				if err := s.limiter.consume(clientIP, packetSize); err != nil {
+					s.log(Warning, "Daily byte quota exceeded",
+						Entry{"client", clientIP},
+						Entry{"connID", connID})
+					return err
+				}
 
-			if err := dc.Send(packet); err != nil {
-				Log(Error, "Failed to send test packet",
-					Entry{"error", err},
-					Entry{"connID", connID})
-				testError <- err
-				return
+				sequence++
+				pacedPackets++
 			}
-			totalBytesSent += uint64(packetSize)
-
-			sequence++
 
 			currentBuffered := dc.BufferedAmount()
 
 			elapsed := time.Since(lastCheckTime).Milliseconds()
-			// 200 matches the adaptInterval in network.go and metrics manager
-			if elapsed >= 200 {
+			// matches the tuner adaptInterval and the metrics manager report interval
+			if elapsed >= s.config.AdaptInterval.Milliseconds() {
 				// Calculate actual bytes transmitted (accounting for buffer changes)
 				bufferChange := int64(currentBuffered) - int64(lastBufferedAmount)
 				actualBytesSent := int64(totalBytesSent)
@@ -104,10 +153,109 @@ func stream(ctx context.Context, dc *webrtc.DataChannel, connID string, testDone
 			}
 
 
-			if time.Since(startTime) >= maxTestDuration {
-				Log(Info, "Max test duration reached", Entry{"connID", connID})
-				return
+			if time.Since(startTime) >= maxDuration {
+				s.log(Info, "Max test duration reached", Entry{"connID", connID})
+				networkTuner.stop()
+				return nil
 			}
 		}
 	}
 }
+
+// drainStream waits until the test packets still queued for sending are gone, at most maxStreamDrain.
+// Leftovers of a download phase that ended above the link rate would otherwise compete with the upload phase
+// on the same association and starve it.
+func (s *Server) drainStream(ctx context.Context, dc dataSender, connID string) {
+	if dc.BufferedAmount() == 0 {
+		return
+	}
+
+	timeout := time.NewTimer(maxStreamDrain)
+	defer timeout.Stop()
+	ticker := time.NewTicker(streamTick)
+	defer ticker.Stop()
+
+	for dc.BufferedAmount() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			s.log(Warning, "Download stream not drained before the upload phase",
+				Entry{"buffered", dc.BufferedAmount()},
+				Entry{"connID", connID})
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// receive measures test packets sent by the client and drives the upload tuner from its own measurements.
+// The client is told which bitrate to send at through bitrate_update messages.
+func (s *Server) receive(ctx context.Context, dc *webrtc.DataChannel, sess *session, networkTuner *NetworkTuner, maxDuration time.Duration, emulator *emulator) error {
+	metrics := NewReceiverMetrics(s.config.AdaptInterval)
+
+	sendBitrate := func(final bool) error {
+		return sess.writeJSON(BitrateUpdateMessage{
+			Type:      MessageBitrateUpdate,
+			Direction: DirectionUpload,
+			Bitrate:   networkTuner.getCurrentBitrate(),
+			Final:     final,
+		})
+	}
+
+	done := make(chan struct{})
+	sendError := make(chan error, 1)
+	var finished bool
+
+	dc.OnMessage(emulator.receiver(func(msg webrtc.DataChannelMessage) {
+		if finished {
+			return
+		}
+
+		m, ok := metrics.Observe(msg.Data, time.Now())
+		if !ok {
+			return
+		}
+
+		networkTuner.SetServerEffectiveRate(m.ServerEffectiveRate)
+		s.adjustBitrate(sess, DirectionUpload, networkTuner, m.LossRate, m.Jitter, m.ActualThroughput, m.ServerEffectiveRate)
+		// also ends the phase for packets racing the timeout, which stops the tuner
+		shouldContinue := !networkTuner.IsTestComplete()
+		if err := sendBitrate(!shouldContinue); err != nil {
+			finished = true
+			sendError <- err
+			return
+		}
+
+		if !shouldContinue {
+			finished = true
+			close(done)
+		}
+	}))
+	defer dc.OnMessage(func(webrtc.DataChannelMessage) {})
+
+	if err := sendBitrate(false); err != nil {
+		s.log(Error, "Failed to send bitrate update",
+			Entry{"error", err},
+			Entry{"connID", sess.connID})
+		return err
+	}
+
+	timeout := time.NewTimer(maxDuration)
+	defer timeout.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+		s.log(Info, "Upload testing complete", Entry{"connID", sess.connID})
+	case err := <-sendError:
+		s.log(Error, "Failed to send bitrate update",
+			Entry{"error", err},
+			Entry{"connID", sess.connID})
+		return err
+	case <-timeout.C:
+		s.log(Info, "Max test duration reached", Entry{"connID", sess.connID})
+		networkTuner.stop()
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package litmus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Decision is what a tuner did with a measurement
+type Decision string
+
+const (
+	DecisionSkipped  Decision = "skipped"  // arrived within the adapt interval of the previous one, not evaluated
+	DecisionHold     Decision = "hold"     // bitrate unchanged
+	DecisionIncrease Decision = "increase" // bitrate raised
+	DecisionDecrease Decision = "decrease" // bitrate lowered
+	DecisionComplete Decision = "complete" // the search ended, the test phase is over
+)
+
+// Sample is one measurement interval of a test and the tuner decision it led to
+type Sample struct {
+	Time                time.Time `json:"time"`
+	Direction           Direction `json:"direction"`
+	Bitrate             int       `json:"bitrate"`               // kbps, target bitrate during the interval
+	ServerEffectiveRate float64   `json:"server_effective_rate"` // bits/second, as sent
+	ActualThroughput    float64   `json:"actual_throughput"`     // bits/second, as received
+	LossRate            float64   `json:"loss_rate"`             // fraction
+	Jitter              float64   `json:"jitter"`                // milliseconds
+	Decision            Decision  `json:"decision"`
+	NextBitrate         int       `json:"next_bitrate"` // kbps, target bitrate after the decision
+}
+
+// Timeline is the sequence of samples of a test, in order
+type Timeline []Sample
+
+var timelineCSVHeader = []string{
+	"time",
+	"direction",
+	"bitrate_kbps",
+	"server_effective_rate_bps",
+	"actual_throughput_bps",
+	"loss_rate",
+	"jitter_ms",
+	"decision",
+	"next_bitrate_kbps",
+}
+
+// WriteCSV writes the timeline as CSV with a header row, times in RFC 3339 with nanoseconds
+func (t Timeline) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(timelineCSVHeader); err != nil {
+		return err
+	}
+
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for _, sample := range t {
+		if err := cw.Write([]string{
+			sample.Time.Format(time.RFC3339Nano),
+			string(sample.Direction),
+			strconv.Itoa(sample.Bitrate),
+			formatFloat(sample.ServerEffectiveRate),
+			formatFloat(sample.ActualThroughput),
+			formatFloat(sample.LossRate),
+			formatFloat(sample.Jitter),
+			string(sample.Decision),
+			strconv.Itoa(sample.NextBitrate),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the timeline as an indented JSON array
+func (t Timeline) WriteJSON(w io.Writer) error {
+	if t == nil {
+		t = Timeline{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+// timelineSlack are the samples a timeline may hold beyond one per adapt interval of each phase,
+// for reports straddling the phase boundaries and clocks drifting apart
+const timelineSlack = 20
+
+// maxTimelineSamples returns how many samples the longest test of c can record, both phases at full duration
+func (c Config) maxTimelineSamples() int {
+	if c.AdaptInterval <= 0 {
+		return timelineSlack
+	}
+	return 2*int(c.MaxTestDuration/c.AdaptInterval) + timelineSlack
+}
+
+// adjustBitrate feeds a measurement to the tuner of a direction, recording it in the session timeline
+// and counting the resulting bitrate steps. Returns the decision, DecisionSkipped for measurements that were
+// not evaluated: those within the adapt interval of the previous one, and those arriving after the search completed.
+// Only evaluated measurements are recorded, so that a client flooding reports cannot grow the timeline.
+func (s *Server) adjustBitrate(sess *session, direction Direction, tuner *NetworkTuner, lossRate, jitter, actualThroughput, serverEffectiveRate float64) Decision {
+	if tuner.IsTestComplete() {
+		return DecisionSkipped
+	}
+
+	before := tuner.getCurrentBitrate()
+	decision := tuner.adjust(Measurement{
+		LossRate:            lossRate,
+		Jitter:              jitter,
+		ActualThroughput:    actualThroughput,
+		ServerEffectiveRate: serverEffectiveRate,
+	})
+	if decision == DecisionSkipped {
+		return decision
+	}
+	after := tuner.getCurrentBitrate()
+
+	if after != before {
+		s.metrics.tunerStepped(direction, after > before)
+		s.emit(sess, Event{
+			Type:            EventBitrateChanged,
+			Direction:       direction,
+			Bitrate:         after,
+			PreviousBitrate: before,
+			Decision:        decision,
+		})
+	}
+
+	sess.record(Sample{
+		Time:                s.config.clock().Now(),
+		Direction:           direction,
+		Bitrate:             before,
+		ServerEffectiveRate: serverEffectiveRate,
+		ActualThroughput:    actualThroughput,
+		LossRate:            lossRate,
+		Jitter:              jitter,
+		Decision:            decision,
+		NextBitrate:         after,
+	})
+	return decision
+}
+
+// SessionTimeline returns the timeline recorded so far by an active session
+func (s *Server) SessionTimeline(id string) (Timeline, bool) {
+	value, ok := s.connections.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return value.(*session).timelineSnapshot(), true
+}
@@ -0,0 +1,106 @@
+package litmus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const defaultTokenTTL = time.Hour
+
+var (
+	ErrInvalidToken = errors.New("invalid result token")
+	ErrTokenExpired = errors.New("result token expired")
+)
+
+// tokenHeader is the JOSE header of every result token, HMAC SHA-256 signed JWTs
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// ResultClaims is the content of a result token: what a test measured, for whom, and until when it can be trusted
+type ResultClaims struct {
+	ID        string             `json:"jti"`       // connection ID of the test
+	ClientIP  string             `json:"client_ip"` // address the test ran from
+	IssuedAt  int64              `json:"iat"`       // unix seconds
+	ExpiresAt int64              `json:"exp"`       // unix seconds
+	Bitrate   int                `json:"bitrate"`   // kbps, usable in both tested directions
+	Download  *NetworkCapability `json:"download,omitempty"`
+	Upload    *NetworkCapability `json:"upload,omitempty"`
+	Profile   string             `json:"profile"` // recommended profile, empty if none passed
+}
+
+// Expires returns the expiry as a time
+func (c ResultClaims) Expires() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// SignResult encodes claims as a JWT signed with key using HMAC SHA-256
+func SignResult(key []byte, claims ResultClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(key, signed)), nil
+}
+
+// VerifyResult checks the signature and expiry of a token issued by a server sharing key, and returns its claims.
+// It is up to the caller to compare ClientIP with the address the token is presented from, if relevant.
+func VerifyResult(key []byte, token string) (*ResultClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, tokenSignature(key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims ResultClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !time.Now().Before(claims.Expires()) {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+func tokenSignature(key []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// resultToken signs the report of a completed session, empty if no signing key is configured
+func (s *Server) resultToken(sess *session, report TestReport) (string, error) {
+	if len(s.config.TokenKey) == 0 {
+		return "", nil
+	}
+
+	ttl := s.config.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	now := time.Now()
+	return SignResult(s.config.TokenKey, ResultClaims{
+		ID:        sess.connID,
+		ClientIP:  hostOf(sess.remoteAddr),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Bitrate:   report.Bitrate,
+		Download:  report.Download,
+		Upload:    report.Upload,
+		Profile:   report.Profile,
+	})
+}
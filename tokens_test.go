@@ -0,0 +1,82 @@
+package litmus
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTokenKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testClaims(expires time.Time) ResultClaims {
+	return ResultClaims{
+		ID:        "conn-1",
+		ClientIP:  "192.0.2.1",
+		IssuedAt:  expires.Add(-time.Hour).Unix(),
+		ExpiresAt: expires.Unix(),
+		Bitrate:   4000,
+		Download:  &NetworkCapability{MaxStableBitrate: 4000},
+		Profile:   "720p",
+	}
+}
+
+// withHeader replaces the JOSE header of token and signs it again with key, as a forger holding the key
+// but choosing the algorithm would
+func withHeader(token, header string, key []byte) string {
+	parts := strings.Split(token, ".")
+	signed := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + parts[1]
+	return signed + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(key, signed))
+}
+
+func TestVerifyResult(t *testing.T) {
+	valid, err := SignResult(testTokenKey, testClaims(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := SignResult(testTokenKey, testClaims(time.Now().Add(-time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(string(payload), `"bitrate":4000`, `"bitrate":9000`, 1)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + parts[2]
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name  string
+		key   []byte
+		token string
+		err   error
+	}{
+		{"round trip", testTokenKey, valid, nil},
+		{"tampered payload", testTokenKey, tampered, ErrInvalidToken},
+		{"wrong key", []byte("another key"), valid, ErrInvalidToken},
+		{"expired", testTokenKey, expired, ErrTokenExpired},
+		{"alg none", testTokenKey, unsigned, ErrInvalidToken},
+		{"alg HS512", testTokenKey, withHeader(valid, `{"alg":"HS512","typ":"JWT"}`, testTokenKey), ErrInvalidToken},
+		{"not a JWT", testTokenKey, "litmus", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyResult(tt.key, tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if claims.ID != "conn-1" || claims.ClientIP != "192.0.2.1" || claims.Bitrate != 4000 ||
+				claims.Download == nil || claims.Download.MaxStableBitrate != 4000 || claims.Profile != "720p" {
+				t.Errorf("claims not verified as signed: %+v", claims)
+			}
+		})
+	}
+}